@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// reOptionKey matches the key of a key=value option. Keys are intentionally
+// restrictive, so things like urls with query strings aren't mistaken for
+// options.
+var reOptionKey = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+// Options are per-check options, e.g. supplied as command arguments.
+type Options map[string]string
+
+// Bool returns the boolean value of an option, or def if it's not set.
+// Options which are set with no value (e.g. "--banner") are true.
+func (o Options) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+
+	if v == "" {
+		return true, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid value for %s: %q (expected true/false)", key, v)
+	}
+
+	return b, nil
+}
+
+// Int returns the integer value of an option, or def if it's not set.
+func (o Options) Int(key string, def int) (int, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid value for %s: %q (expected a number)", key, v)
+	}
+
+	return n, nil
+}
+
+// String returns the value of an option, or def if it's not set.
+func (o Options) String(key, def string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+
+	return def
+}
+
+// Merge returns a copy of the options, with the provided options overriding
+// existing ones.
+func (o Options) Merge(other Options) Options {
+	out := Options{}
+	for k, v := range o {
+		out[k] = v
+	}
+
+	for k, v := range other {
+		out[k] = v
+	}
+
+	return out
+}
+
+// Args are the parsed arguments of a command, in the form of:
+//
+//	[--flag] [--key=value] [key=value] <item> [key=value]... <item> ...
+//
+// key=value options following an item apply only to that item, where
+// --flag/--key=value options (and key=value options before any items) apply
+// to the command as a whole. Values can be quoted (e.g. match="some text").
+type Args struct {
+	Global Options
+	Items  []*ArgItem
+}
+
+// ArgItem is a single positional argument, and the options that apply to it.
+type ArgItem struct {
+	Value   string
+	Options Options
+}
+
+// ArgError is an error encountered while parsing command arguments.
+type ArgError struct {
+	// Pos is the position (in characters) within the arguments the error
+	// occurred at.
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *ArgError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s (at position %d)", e.Msg, e.Pos)
+	}
+
+	return fmt.Sprintf("%s (at position %d: `%s`)", e.Msg, e.Pos, e.Token)
+}
+
+// Values returns the values of all positional arguments.
+func (a *Args) Values() (values []string) {
+	for _, item := range a.Items {
+		values = append(values, item.Value)
+	}
+
+	return values
+}
+
+// Options returns the options for the provided item, merged with the global
+// options.
+func (a *Args) Options(item *ArgItem) Options {
+	return a.Global.Merge(item.Options)
+}
+
+// token is a single whitespace separated argument, with quotes removed.
+type token struct {
+	pos int
+	raw string
+	// key is set if the token is an option. value is the value of the
+	// option, or the whole token if it's not an option.
+	key   string
+	value string
+	flag  bool
+}
+
+// tokenize splits the input into tokens, respecting quoted strings at the
+// start of tokens and option values.
+func tokenize(in string) (tokens []*token, err error) {
+	runes := []rune(in)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		tok := &token{pos: i}
+		var buf []rune
+		var keyEnd = -1
+
+		for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+			// Quotes only quote at the start of a token or of an option
+			// value, so quotes within e.g. urls are kept as they are.
+			quoting := i == tok.pos || (keyEnd >= 0 && keyEnd == len(buf)-1 && runes[i-1] == '=' &&
+				reOptionKey.MatchString(strings.TrimPrefix(string(buf[:keyEnd]), "--")))
+
+			switch {
+			case quoting && (runes[i] == '"' || runes[i] == '\''):
+				quote, start := runes[i], i
+				for i++; i < len(runes) && runes[i] != quote; i++ {
+					if runes[i] == '\\' && quote == '"' && i+1 < len(runes) {
+						i++
+					}
+					buf = append(buf, runes[i])
+				}
+
+				if i >= len(runes) {
+					return nil, &ArgError{Pos: start, Token: string(runes[start:]), Msg: "unterminated quote"}
+				}
+			case runes[i] == '=':
+				if keyEnd < 0 {
+					keyEnd = len(buf)
+				}
+				buf = append(buf, runes[i])
+			default:
+				buf = append(buf, runes[i])
+			}
+		}
+
+		tok.raw = string(runes[tok.pos:i])
+		tok.value = string(buf)
+
+		name := tok.value
+		if strings.HasPrefix(name, "--") {
+			tok.flag = true
+			name = name[2:]
+		}
+
+		if keyEnd >= 0 {
+			key := string(buf[:keyEnd])
+			if tok.flag {
+				key = strings.TrimPrefix(key, "--")
+			}
+
+			if reOptionKey.MatchString(key) {
+				tok.key = strings.ToLower(key)
+				tok.value = string(buf[keyEnd+1:])
+			} else if tok.flag || key == "" {
+				return nil, &ArgError{Pos: tok.pos, Token: tok.raw, Msg: "invalid option name"}
+			}
+		} else if tok.flag {
+			if !reOptionKey.MatchString(name) {
+				return nil, &ArgError{Pos: tok.pos, Token: tok.raw, Msg: "invalid flag name"}
+			}
+
+			tok.key = strings.ToLower(name)
+			tok.value = ""
+		}
+
+		tokens = append(tokens, tok)
+	}
+
+	return tokens, nil
+}
+
+// parseArgs parses command arguments. See Args for the supported syntax.
+func parseArgs(in string) (*Args, error) {
+	tokens, err := tokenize(in)
+	if err != nil {
+		return nil, err
+	}
+
+	args := &Args{Global: Options{}}
+	for _, tok := range tokens {
+		if tok.key == "" {
+			args.Items = append(args.Items, &ArgItem{Value: tok.value, Options: Options{}})
+			continue
+		}
+
+		if tok.flag || len(args.Items) == 0 {
+			args.Global[tok.key] = tok.value
+			continue
+		}
+
+		args.Items[len(args.Items)-1].Options[tok.key] = tok.value
+	}
+
+	return args, nil
+}
+
+// splitFields splits off the first n whitespace separated fields of in,
+// followed by the remainder (if any) as-is, other than surrounding
+// whitespace. Nothing is parsed, so the remainder can be free text.
+func splitFields(in string, n int) (fields []string) {
+	in = strings.TrimSpace(in)
+
+	for len(fields) < n && in != "" {
+		i := strings.IndexFunc(in, unicode.IsSpace)
+		if i < 0 {
+			return append(fields, in)
+		}
+
+		fields = append(fields, in[:i])
+		in = strings.TrimSpace(in[i:])
+	}
+
+	if in != "" {
+		fields = append(fields, in)
+	}
+
+	return fields
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		in     string
+		global Options
+		items  []*ArgItem
+	}{
+		{"", Options{}, nil},
+		{"web01", Options{}, []*ArgItem{{Value: "web01", Options: Options{}}}},
+		{
+			"--banner interval=10s web01 fails=2/5 web02",
+			Options{"banner": "", "interval": "10s"},
+			[]*ArgItem{
+				{Value: "web01", Options: Options{"fails": "2/5"}},
+				{Value: "web02", Options: Options{}},
+			},
+		},
+		{
+			`https://example.com/?a=b match="some text" --timeout=5s`,
+			Options{"timeout": "5s"},
+			[]*ArgItem{{Value: "https://example.com/?a=b", Options: Options{"match": "some text"}}},
+		},
+		{
+			`example.com regex='a "b"' match="x \"y\""`,
+			Options{},
+			[]*ArgItem{{Value: "example.com", Options: Options{"regex": `a "b"`, "match": `x "y"`}}},
+		},
+		{"Web01 Status=200", Options{}, []*ArgItem{{Value: "Web01", Options: Options{"status": "200"}}}},
+		// Quotes within a token are kept.
+		{"https://x/it's", Options{}, []*ArgItem{{Value: "https://x/it's", Options: Options{}}}},
+		{
+			`https://example.com/?q="a"&b='c' match="x y"`,
+			Options{},
+			[]*ArgItem{{Value: `https://example.com/?q="a"&b='c'`, Options: Options{"match": "x y"}}},
+		},
+		{`web01 match=it's`, Options{}, []*ArgItem{{Value: "web01", Options: Options{"match": "it's"}}}},
+		{`"web 01" --match='a b'`, Options{"match": "a b"}, []*ArgItem{{Value: "web 01", Options: Options{}}}},
+	}
+
+	for _, tt := range tests {
+		args, err := parseArgs(tt.in)
+		if err != nil {
+			t.Errorf("parseArgs(%q) returned error: %s", tt.in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(args.Global, tt.global) {
+			t.Errorf("parseArgs(%q) global = %v, want %v", tt.in, args.Global, tt.global)
+		}
+
+		if !reflect.DeepEqual(args.Items, tt.items) {
+			t.Errorf("parseArgs(%q) items = %v, want %v", tt.in, args.Values(), tt.items)
+		}
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos int
+	}{
+		{`web01 match="unterminated`, 12},
+		{"web01 --=x", 6},
+		{"web01 --b@d", 6},
+		{"web01 =x", 6},
+	}
+
+	for _, tt := range tests {
+		_, err := parseArgs(tt.in)
+		aerr, ok := err.(*ArgError)
+		if !ok {
+			t.Errorf("parseArgs(%q) error = %v, want *ArgError", tt.in, err)
+			continue
+		}
+
+		if aerr.Pos != tt.pos {
+			t.Errorf("parseArgs(%q) error position = %d, want %d", tt.in, aerr.Pos, tt.pos)
+		}
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want []string
+	}{
+		{"", 2, nil},
+		{"web*", 2, []string{"web*"}},
+		{" web*  30m ", 2, []string{"web*", "30m"}},
+		{"web* 30m don't reboot  \"now\"", 2, []string{"web*", "30m", "don't reboot  \"now\""}},
+	}
+
+	for _, tt := range tests {
+		if got := splitFields(tt.in, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitFields(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration which can be decoded from configuration
+// strings, e.g. "10s" or "7d" (see parseDuration).
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = parseDuration(string(text))
+	return err
+}
+
+// ProbeConfig controls how often a check is probed, and how many probes must
+// fail before it is considered offline.
+type ProbeConfig struct {
+	// Interval is the time between each round of probes.
+	Interval Duration `toml:"interval"`
+	// Attempts is the amount of probes in each round.
+	Attempts int `toml:"attempts"`
+	// AttemptInterval is the time between each probe in a round.
+	AttemptInterval Duration `toml:"attempt_interval"`
+	// Fails is the amount of probes in a round which must fail for the
+	// check to be considered offline.
+	Fails int `toml:"fails"`
+	// HealthyDelay is the additional time to wait after a healthy round,
+	// before probing again.
+	HealthyDelay Duration `toml:"healthy_delay"`
+}
+
+// setDefaults fills in any unset values with the defaults.
+func (c *ProbeConfig) setDefaults() {
+	if c.Interval.Duration <= 0 {
+		c.Interval.Duration = 5 * time.Second
+	}
+
+	if c.Attempts < 1 {
+		c.Attempts = 3
+	}
+
+	if c.AttemptInterval.Duration <= 0 {
+		c.AttemptInterval.Duration = 2 * time.Second
+	}
+
+	if c.Fails < 1 || c.Fails > c.Attempts {
+		c.Fails = c.Attempts
+	}
+
+	if c.HealthyDelay.Duration <= 0 {
+		c.HealthyDelay.Duration = 25 * time.Second
+	}
+}
+
+// Set overrides a single setting, e.g. from a command option. fails can be
+// supplied as "fails/attempts" (e.g. "2/5").
+func (c *ProbeConfig) Set(key, value string) (err error) {
+	switch key {
+	case "interval":
+		c.Interval.Duration, err = parseDuration(value)
+	case "attempts":
+		if c.Attempts, err = strconv.Atoi(value); err == nil && c.Fails > c.Attempts {
+			c.Fails = c.Attempts
+		}
+	case "attempt_interval", "spacing":
+		c.AttemptInterval.Duration, err = parseDuration(value)
+	case "fails":
+		parts := strings.SplitN(value, "/", 2)
+		if c.Fails, err = strconv.Atoi(parts[0]); err == nil && len(parts) == 2 {
+			c.Attempts, err = strconv.Atoi(parts[1])
+		}
+	case "healthy_delay", "delay":
+		c.HealthyDelay.Duration, err = parseDuration(value)
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %q", key, value)
+	}
+
+	switch {
+	case c.Interval.Duration < time.Second:
+		return errors.New("interval must be at least 1s")
+	case c.Attempts < 1 || c.Attempts > 20:
+		return errors.New("attempts must be between 1 and 20")
+	case c.Fails < 1 || c.Fails > c.Attempts:
+		return errors.New("fails must be between 1 and the amount of attempts")
+	}
+
+	return nil
+}
+
+// cadenceOptions are the options supported by ProbeConfig.Set, in the order
+// they should be applied.
+var cadenceOptions = []string{"interval", "attempts", "attempt_interval", "spacing", "fails", "healthy_delay", "delay"}
+
+// Apply overrides the settings which are present in the provided options.
+func (c *ProbeConfig) Apply(opts Options) error {
+	for _, key := range cadenceOptions {
+		if value, ok := opts[key]; ok {
+			if err := c.Set(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c ProbeConfig) String() string {
+	return fmt.Sprintf(
+		"every %s, offline if %d/%d probes fail (%s apart)",
+		c.Interval.Duration, c.Fails, c.Attempts, c.AttemptInterval.Duration,
+	)
+}
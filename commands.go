@@ -1,20 +1,40 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/nlopes/slack"
 )
 
 var reCommand = regexp.MustCompile(`^!([[:word:]]+)(?: (.*)?)?`)
 
-func cmdHandler(msg *slack.Message, cmd, args string) error {
+// maxHistory is the maximum amount of outages shown by !history.
+const maxHistory = 20
+
+const historyTimeFormat = "Jan 02 15:04 MST"
+
+// expiryTimeFormat is the format used when showing when a check expires.
+const expiryTimeFormat = "Mon 15:04 MST"
+
+// freeTextCommands are commands which take free text (e.g. a reason), so
+// their arguments aren't parsed with parseArgs, where quotes and options have
+// special meaning.
+var freeTextCommands = map[string]bool{"maint": true, "maintenance": true}
+
+func cmdHandler(msg *Message, cmd, args string) error {
 	var reply string
-	var err error
+
+	argv := &Args{Global: Options{}}
+	if !freeTextCommands[cmd] {
+		var err error
+		if argv, err = parseArgs(args); err != nil {
+			replyTo(msg, msg.ThreadTimestamp != "", fmt.Sprintf("invalid arguments: %s", err))
+			return err
+		}
+	}
 
 	switch cmd {
 	case "enable":
@@ -57,7 +77,7 @@ func cmdHandler(msg *slack.Message, cmd, args string) error {
 		reply = "sending cancellation signal to active checks."
 		break
 	case "clear", "stop", "kill", "done":
-		if args == "" {
+		if len(argv.Items) == 0 {
 			if msg.ThreadTimestamp != "" {
 				// Check to see if the main message has any checks. If they didn't
 				// specify any args, just clear that threads checks.
@@ -76,64 +96,253 @@ func cmdHandler(msg *slack.Message, cmd, args string) error {
 			break
 		}
 
-		argv := strings.Fields(args)
-		for _, query := range argv {
+		queries := argv.Values()
+		for _, query := range queries {
 			hostGroup.GlobRemove(query, "")
 		}
 
-		reply = "sending cancellation signal to checks matching: `" + strings.Join(argv, "`, `") + "`"
+		reply = "sending cancellation signal to checks matching: `" + strings.Join(queries, "`, `") + "`"
 		break
 	case "ping", "check", "pong":
-		argv := strings.Fields(args)
-
-		if len(argv) == 0 {
-			reply = "no hostname or ip address suppled."
+		if len(argv.Items) == 0 {
+			reply = "no hostname, ip address, or host:port supplied."
 			break
 		}
 
-		for _, query := range argv {
-			var ip net.IP
-			var addrs []net.IP
+		// "for 2h" and "until 18:00" apply to all hosts in the command.
+		var items []*ArgItem
+		for i := 0; i < len(argv.Items); i++ {
+			value := strings.ToLower(argv.Items[i].Value)
+			if (value == "for" || value == "until") && i+1 < len(argv.Items) {
+				argv.Global[value] = argv.Items[i+1].Value
 
-			ip = net.ParseIP(query)
-			if ip == nil {
-				addrs, err = net.LookupIP(query)
-				if err != nil {
-					reply += fmt.Sprintf("invalid addr/host: `%s`\n", query)
-					continue
+				// Options following the duration belong to the previous
+				// host (or all hosts, if there isn't one).
+				opts := argv.Global
+				if len(items) > 0 {
+					opts = items[len(items)-1].Options
+				}
+				for k, v := range argv.Items[i+1].Options {
+					opts[k] = v
 				}
 
-				ip = addrs[0]
+				i++
+				continue
 			}
 
-			if ok, buffer := hostGroup.Exists(query); ok {
-				reply = fmt.Sprintf("That host is already being monitored! (`%s`)", buffer)
+			items = append(items, argv.Items[i])
+		}
+
+		if len(items) == 0 {
+			reply = "no hostname, ip address, or host:port supplied."
+			break
+		}
+
+		for _, item := range items {
+			reply += addCheck(msg, item.Value, argv.Options(item))
+		}
+
+		break
+	case "maint", "maintenance":
+		values := splitFields(args, 2)
+
+		if len(values) == 0 {
+			windows := activeMaintenance()
+			if len(windows) == 0 && len(conf.Maintenance) == 0 {
+				reply = "no maintenance windows. usage: `!maint <query> <duration> [reason]` (e.g. `!maint web* 30m reboots`)"
 				break
 			}
 
-			host := &Host{
-				closer:    make(chan struct{}, 1),
-				Origin:    msg,
-				IP:        ip,
-				Added:     time.Now(),
-				Buffer:    "via !check",
-				Highlight: []string{},
+			for _, w := range windows {
+				reply += fmt.Sprintf("• `%s` until `%s` by %s: %s\n", w.Match, w.End.Format(expiryTimeFormat), userName(w.Transport, w.User), w.Reason)
 			}
 
-			if ch := slackIDToChannel(msg.Channel); ch != "" {
-				host.Buffer += " in " + ch
+			for _, m := range conf.Maintenance {
+				reply += fmt.Sprintf("• `%s` at `%s` for `%s` (config): %s\n", m.Match, m.Schedule, m.Duration.Duration, m.Reason)
 			}
 
-			go host.Watch()
-			err = hostGroup.Add(query, host)
-			if !conf.NotifyOnStart {
-				if err != nil {
-					reply += fmt.Sprintf("error adding `%s`: %s\n", query, err)
-					continue
-				}
+			break
+		}
+
+		if len(values) < 2 {
+			reply = "usage: `!maint <query> <duration> [reason]`, or `!maint <query> end`"
+			break
+		}
+
+		if strings.ToLower(values[1]) == "end" {
+			if endMaintenance(values[0]) == 0 {
+				reply = fmt.Sprintf("no maintenance windows for `%s`.", values[0])
+				break
+			}
+
+			reply = fmt.Sprintf("ended maintenance for `%s`, a summary will be posted for matching checks.", values[0])
+			break
+		}
+
+		dur, err := parseDuration(values[1])
+		if err != nil || dur <= 0 {
+			reply = fmt.Sprintf("invalid duration: `%s`", values[1])
+			break
+		}
+
+		var reason string
+		if len(values) > 2 {
+			reason = values[2]
+		}
+
+		w, err := addMaintenance(values[0], dur, reason, msg)
+		if err != nil {
+			reply = fmt.Sprintf("error adding maintenance window: %s", err)
+			break
+		}
+
+		reply = fmt.Sprintf(
+			"maintenance window for `%s` until `%s`. matching checks will keep being probed, with a summary posted once it ends.",
+			w.Match, w.End.Format(expiryTimeFormat),
+		)
+		break
+	case "report":
+		values := argv.Values()
+
+		if len(values) == 0 {
+			reply = "usage: `!report <host|ip|#incident> [window]` (e.g. `!report web01 7d`, `!report #12`)"
+			break
+		}
+
+		window := "7d"
+		if len(values) > 1 {
+			window = values[1]
+		}
+
+		since, err := parseDuration(window)
+		if err != nil {
+			reply = fmt.Sprintf("invalid window: `%s` (e.g. 12h, 7d, 2w)", window)
+			break
+		}
+
+		out, err := report(values[0], time.Now().Add(-since))
+		if err != nil {
+			reply = err.Error()
+			break
+		}
+
+		reply = "```\n" + out + "```"
+		break
+	case "extend":
+		values := argv.Values()
+
+		if len(values) != 2 {
+			reply = "usage: `!extend <query> <duration>` (e.g. `!extend web01 1h`)"
+			break
+		}
+
+		dur, err := parseDuration(values[1])
+		if err != nil || dur <= 0 {
+			reply = fmt.Sprintf("invalid duration: `%s`", values[1])
+			break
+		}
+
+		extended := hostGroup.Extend(values[0], dur)
+		if len(extended) == 0 {
+			reply = fmt.Sprintf("no checks matching `%s`.", values[0])
+			break
+		}
+
+		for _, host := range extended {
+			reply += fmt.Sprintf("extended `%s` until `%s`\n", host.Probe.Target(), host.expires().Format(expiryTimeFormat))
+		}
 
-				reply += fmt.Sprintf("added check for `%s`\n", query)
+		break
+	case "dns":
+		if len(argv.Items) == 0 {
+			reply = "no name supplied. usage: `!dns <name> [type] [@resolver]`"
+			break
+		}
+
+		opts := argv.Options(argv.Items[0])
+		for _, item := range argv.Items[1:] {
+			if strings.HasPrefix(item.Value, "@") {
+				opts["resolver"] = item.Value[1:]
+				continue
 			}
+
+			opts["type"] = item.Value
+		}
+
+		reply = addCheck(msg, "dns://"+strings.TrimPrefix(argv.Items[0].Value, "dns://"), opts)
+		break
+	case "cert", "tls", "ssl":
+		if len(argv.Items) == 0 {
+			reply = "no host[:port] supplied."
+			break
+		}
+
+		for _, query := range argv.Values() {
+			probe, _, err := newTLSProbe(strings.TrimPrefix(query, "tls://"), argv.Global)
+			if err != nil {
+				reply += fmt.Sprintf("invalid addr/host: `%s`\n", query)
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+			info, err := probe.Inspect(ctx)
+			cancel()
+			if err != nil {
+				reply += fmt.Sprintf("unable to check certificate for `%s`: %s\n", probe.Target(), err)
+				continue
+			}
+
+			reply += fmt.Sprintf("certificate for `%s`:\n```\n%s```\n", probe.Target(), info)
+		}
+
+		break
+	case "history", "outages":
+		values := argv.Values()
+
+		if len(values) == 0 {
+			reply = "no host or ip supplied. usage: `!history <host|ip> [window]` (e.g. `!history web01 7d`)"
+			break
+		}
+
+		window := "7d"
+		if len(values) > 1 {
+			window = values[1]
+		}
+
+		since, err := parseDuration(window)
+		if err != nil {
+			reply = fmt.Sprintf("invalid window `%s`: %s", window, err)
+			break
+		}
+
+		outages := GetOutages(values[0], time.Now().Add(-since))
+		if len(outages) == 0 {
+			reply = fmt.Sprintf("no outages recorded for `%s` in the last `%s`.", values[0], window)
+			break
+		}
+
+		reply = fmt.Sprintf("*%d outage(s) for `%s` in the last `%s`:*\n", len(outages), values[0], window)
+
+		// Only show the most recent outages.
+		if len(outages) > maxHistory {
+			reply += fmt.Sprintf("_(only showing the last %d)_\n", maxHistory)
+			outages = outages[len(outages)-maxHistory:]
+		}
+
+		for _, outage := range outages {
+			end := "*ongoing*"
+			if !outage.End.IsZero() {
+				end = "`" + outage.End.Format(historyTimeFormat) + "`"
+				if !outage.Resolved {
+					end += " (check stopped while offline)"
+				}
+			}
+
+			reply += fmt.Sprintf(
+				"> `%s` → %s (`%s`) %s, watch by %s (%s)\n",
+				outage.Start.Format(historyTimeFormat), end, outage.Duration().Truncate(time.Second),
+				outage.Target, userName(outage.Transport, outage.User), outage.Buffer,
+			)
 		}
 
 		break
@@ -144,6 +353,22 @@ func cmdHandler(msg *slack.Message, cmd, args string) error {
 > |!active| lists all active host/ip checks
 > |!clearall| clears all checks
 > |!clear [query]| clear checks matching *query*, or all of *your* checks
+> |!check <host>[:port] [options] [<host> [options]]...| start monitoring a host/ip via ping, or a tcp port if one is supplied
+>     options follow the host they apply to (or use |--key=value| for all hosts), and can be quoted (|match="some text"|)
+>     all checks: |interval=10s|, |fails=2/5| (offline if 2 of 5 probes fail), |attempts=5|, |spacing=2s|, |delay=25s|
+>     tcp: |banner=true|
+>     lifetime: |for 2h| or |until 18:00| (all hosts), |removal=30m| (stop once online for this long, or |never|)
+>     dependencies: |via=<parent>| (reported as unreachable rather than offline if the parent is down)
+> |!extend <query> <duration>| keep watching checks matching *query* for longer
+> |!maint [<query> <duration> [reason]]| suppress notifications for checks matching *query*, or list maintenance windows
+> |!maint <query> end| end a maintenance window early
+> |!check <url> [options]| start monitoring an http(s) url
+>     options: |method=HEAD|, |status=200-299|, |match=text|, |regex=expr|, |redirects=true|, |timeout=5s|
+> |!check tls://<host>[:port] [threshold=14]| start monitoring a tls certificate (changes, expiry, validity)
+> |!cert <host>[:port]| check the tls certificate of a host, once
+> |!dns <name> [type] [@resolver]| watch dns records (a, aaaa, cname, mx, txt) for changes
+> |!history <host|ip> [window]| list recent outages (window defaults to 7d, e.g. 12h, 2w)
+> |!report <host|ip|#incident> [window]| markdown timeline of a check or incident, for postmortems
 > |!help| this help info
 > |message-reactions| start monitoring by adding the :%s: reaction to a message with an ip/host`, "|", "`", -1)
 		reply = fmt.Sprintf(reply, conf.ReactionTrigger)
@@ -153,11 +378,91 @@ func cmdHandler(msg *slack.Message, cmd, args string) error {
 
 	if reply != "" {
 		if msg.ThreadTimestamp != "" {
-			slackReply(msg, true, reply)
+			replyTo(msg, true, reply)
 		} else {
-			slackReply(msg, false, reply)
+			replyTo(msg, false, reply)
 		}
 	}
 
 	return nil
 }
+
+// addCheck starts watching the provided query with the provided options
+// (probe and cadence options), returning the reply that should be sent to the
+// user.
+func addCheck(msg *Message, query string, opts Options) string {
+	probe, ip, err := newProbe(query, opts)
+	if err != nil {
+		return fmt.Sprintf("invalid check `%s`: %s\n", query, err)
+	}
+
+	cadence := conf.Probe
+	if err = cadence.Apply(opts); err != nil {
+		return fmt.Sprintf("invalid check `%s`: %s\n", query, err)
+	}
+
+	if ok, buffer := hostGroup.Exists(query); ok {
+		return fmt.Sprintf("That host is already being monitored! (`%s`)\n", buffer)
+	}
+
+	host := &Host{
+		closer:    make(chan struct{}, 1),
+		Origin:    msg,
+		IP:        ip,
+		Query:     query,
+		Probe:     probe,
+		Added:     time.Now(),
+		Buffer:    "via !check",
+		Highlight: []string{},
+		Cadence:   cadence,
+		Options:   opts,
+	}
+
+	if err = host.setLifetime(opts); err != nil {
+		return fmt.Sprintf("invalid check `%s`: %s\n", query, err)
+	}
+
+	if ch := getTransport(msg.Transport).ChannelName(msg.Channel); ch != "" {
+		host.Buffer += " in " + ch
+	}
+
+	if err = hostGroup.Add(query, host); err != nil {
+		return fmt.Sprintf("error adding `%s`: %s\n", query, err)
+	}
+
+	// Read before the host is being watched, after which it's guarded by
+	// host.mu.
+	expires := host.Expires
+
+	go host.Watch()
+	if conf.NotifyOnStart {
+		return ""
+	}
+
+	if !expires.IsZero() {
+		return fmt.Sprintf("added check for `%s` until `%s`\n", query, expires.Format(expiryTimeFormat))
+	}
+
+	return fmt.Sprintf("added check for `%s`\n", query)
+}
+
+// parseDuration is like time.ParseDuration, however it also supports days
+// and weeks (e.g. "7d", "2w").
+func parseDuration(in string) (time.Duration, error) {
+	if len(in) > 1 {
+		n, err := strconv.Atoi(in[:len(in)-1])
+
+		switch in[len(in)-1] {
+		case 'd':
+			if err == nil {
+				return time.Duration(n) * 24 * time.Hour, nil
+			}
+		case 'w':
+			if err == nil {
+				return time.Duration(n) * 7 * 24 * time.Hour, nil
+			}
+		}
+	}
+
+	return time.ParseDuration(in)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// consoleTransport reads messages from stdin, and writes replies to stdout,
+// allowing ponger to be used (and tested) without a chat service. Each line
+// is a message, optionally prefixed with the user, channel and thread it is
+// sent as:
+//
+//	[@user] [#channel] [^thread] <text>
+//
+// Reactions are added and removed with "+emoji <id>" and "-emoji <id>"
+// (optionally prefixed with @user), where id is the id of a message, as
+// shown in brackets when it's sent.
+type consoleTransport struct {
+	in  io.Reader
+	out io.Writer
+
+	mu       sync.Mutex
+	lastID   int
+	messages map[string]*Message
+}
+
+func newConsoleTransport() *consoleTransport {
+	return &consoleTransport{in: os.Stdin, out: os.Stdout, messages: make(map[string]*Message)}
+}
+
+func (c *consoleTransport) Name() string { return "console" }
+
+func (c *consoleTransport) IncomingChannel() string {
+	if conf.IncomingChannel == "" {
+		return "#console"
+	}
+
+	return conf.IncomingChannel
+}
+
+func (c *consoleTransport) Run(handler EventHandler) error {
+	fmt.Fprintln(c.out, restartMessage())
+	fmt.Fprintln(c.out, "_syntax: [@user] [#channel] [^thread] <text>, or [@user] +emoji/-emoji <id> to react_")
+
+	scanner := bufio.NewScanner(c.in)
+	for scanner.Scan() {
+		ev, err := c.parse(scanner.Text())
+		if err != nil {
+			fmt.Fprintf(c.out, "error: %s\n", err)
+			continue
+		}
+
+		if ev != nil {
+			handler(c, ev)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Keep running (and printing notifications) when input is closed, e.g.
+	// when commands are piped in, rather than shutting down.
+	logger.Print("console input closed")
+	select {}
+}
+
+// parse parses a single line of input. See consoleTransport for the syntax.
+func (c *consoleTransport) parse(line string) (*Event, error) {
+	msg := &Message{
+		Transport: c.Name(),
+		User:      "console",
+		Channel:   strings.TrimPrefix(c.IncomingChannel(), "#"),
+	}
+
+	fields := strings.Fields(line)
+	for len(fields) > 0 && len(fields[0]) > 1 {
+		switch fields[0][0] {
+		case '@':
+			msg.User = fields[0][1:]
+		case '#':
+			msg.Channel = strings.ToLower(fields[0][1:])
+		case '^':
+			msg.ThreadTimestamp = fields[0][1:]
+		case '+', '-':
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("usage: %s <id>", fields[0])
+			}
+
+			c.mu.Lock()
+			target, ok := c.messages[fields[1]]
+			c.mu.Unlock()
+			if !ok {
+				return nil, fmt.Errorf("unknown message: %q", fields[1])
+			}
+
+			return &Event{
+				Message:         target,
+				Reaction:        fields[0][1:],
+				ReactionUser:    msg.User,
+				ReactionRemoved: fields[0][0] == '-',
+			}, nil
+		default:
+			msg.Text = strings.Join(fields, " ")
+			fields = nil
+			continue
+		}
+
+		fields = fields[1:]
+	}
+
+	if msg.Text == "" {
+		return nil, nil
+	}
+
+	msg.Timestamp = c.store(msg)
+	fmt.Fprintf(c.out, "[%s] %s <%s> %s\n", msg.Timestamp, c.location(msg.Channel, msg.ThreadTimestamp), msg.User, msg.Text)
+
+	return &Event{Message: msg}, nil
+}
+
+// store keeps track of a message so it can be reacted to, returning its id.
+func (c *consoleTransport) store(msg *Message) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastID++
+	id := strconv.Itoa(c.lastID)
+	c.messages[id] = msg
+
+	return id
+}
+
+// location returns the channel (and thread) of a message, for display.
+func (c *consoleTransport) location(channel, thread string) string {
+	if thread != "" {
+		return "#" + channel + " ^" + thread
+	}
+
+	return "#" + channel
+}
+
+func (c *consoleTransport) Reply(msg *Message, thread bool, text string) error {
+	var ts string
+	if thread {
+		ts = msg.ThreadTimestamp
+		if ts == "" {
+			ts = msg.Timestamp
+		}
+	}
+
+	reply := &Message{Transport: c.Name(), Channel: msg.Channel, User: "ponger", Text: text, ThreadTimestamp: ts}
+	reply.Timestamp = c.store(reply)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := fmt.Fprintf(c.out, "[%s] %s <ponger> %s\n", reply.Timestamp, c.location(msg.Channel, ts), strings.TrimRight(text, "\n"))
+	return err
+}
+
+func (c *consoleTransport) Post(channel, text string) (string, error) {
+	msg := &Message{Transport: c.Name(), Channel: channel, User: "ponger", Text: text}
+	msg.Timestamp = c.store(msg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := fmt.Fprintf(c.out, "[%s] %s <ponger> %s\n", msg.Timestamp, c.location(channel, ""), strings.TrimRight(text, "\n"))
+	return msg.Timestamp, err
+}
+
+func (c *consoleTransport) Update(channel, id, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg, ok := c.messages[id]; ok {
+		msg.Text = text
+	}
+
+	_, err := fmt.Fprintf(c.out, "[%s edited] %s <ponger> %s\n", id, c.location(channel, ""), strings.TrimRight(text, "\n"))
+	return err
+}
+
+func (c *consoleTransport) ChannelID(name string) (string, error) {
+	return strings.ToLower(strings.TrimPrefix(name, "#")), nil
+}
+
+func (c *consoleTransport) ChannelName(id string) string        { return "#" + id }
+func (c *consoleTransport) UserName(id string) string           { return "@" + id }
+func (c *consoleTransport) Mention(id string) string            { return "@" + id }
+func (c *consoleTransport) Permalink(channel, id string) string { return "" }
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incidentBucket is the bucket that incidents are stored in.
+const incidentBucket = "incidents"
+
+// Incident is a group of checks which went offline around the same time
+// (see Host.correlate).
+type Incident struct {
+	ID     int       `storm:"id,increment"`
+	Opened time.Time `storm:"index"`
+	// Closed is zero while any of the checks are still offline.
+	Closed time.Time
+	Hosts  []*IncidentHost
+
+	// Transport, Channel and Message are where the message which is updated
+	// as the incident changes was posted.
+	Transport string
+	Channel   string
+	Message   string
+}
+
+// IncidentHost is a single check which is part of an incident.
+type IncidentHost struct {
+	Check  string
+	Target string
+	Addr   string
+	// Transport, Channel and Thread are the origin of the check.
+	Transport string
+	Channel   string
+	Thread    string
+	Down      time.Time
+	// Up is zero while the check is still offline.
+	Up time.Time
+	// Stopped is true if the check was stopped before it came back online.
+	Stopped bool
+}
+
+// resolved returns true if the check is back online, or was stopped.
+func (ih *IncidentHost) resolved() bool {
+	return !ih.Up.IsZero() || ih.Stopped
+}
+
+var correlator = struct {
+	sync.Mutex
+	// posting is held while posting or updating an incident message (before
+	// locking the correlator), so messages are never updated with an older
+	// state of the incident.
+	posting sync.Mutex
+	// pending are checks which recently went offline, which aren't part of
+	// an incident.
+	pending []*IncidentHost
+	// incidents are the open incidents, by id.
+	incidents map[int]*Incident
+}{incidents: make(map[int]*Incident)}
+
+// incidentHost returns the host as part of an incident.
+func (h *Host) incidentHost() *IncidentHost {
+	ih := &IncidentHost{
+		Check:     h.ID,
+		Target:    h.Probe.Target(),
+		Transport: h.Origin.Transport,
+		Channel:   h.Origin.Channel,
+		Thread:    h.Origin.ThreadTimestamp,
+		Down:      time.Now(),
+	}
+
+	if ih.Thread == "" {
+		ih.Thread = h.Origin.Timestamp
+	}
+
+	if h.IP != nil {
+		ih.Addr = h.IP.String()
+	}
+
+	return ih
+}
+
+// correlate tracks the host going offline, opening an incident if enough
+// checks have gone offline within the configured window, or adding the host
+// to an open incident. Returns true if the host is part of an incident, in
+// which case the incident message is updated rather than notifying in the
+// thread of the check.
+func (h *Host) correlate() bool {
+	if conf.Correlation.MinHosts < 1 {
+		return false
+	}
+
+	// The incident message is posted or updated once the correlator is
+	// unlocked, so other checks aren't held up by it.
+	inc, opened := h.addIncident()
+	switch {
+	case inc == nil:
+		return false
+	case opened:
+		inc.post()
+	default:
+		inc.update()
+	}
+
+	return true
+}
+
+// addIncident adds the host to an open incident, or opens one with the
+// pending checks, returning the incident (and true if it was just opened).
+// Returns nil if the host isn't part of an incident.
+func (h *Host) addIncident() (inc *Incident, opened bool) {
+	window := conf.Correlation.Window.Duration
+	now := time.Now()
+	ih := h.incidentHost()
+
+	correlator.Lock()
+	defer correlator.Unlock()
+
+	for _, inc := range correlator.incidents {
+		if now.Sub(inc.lastDown()) > window {
+			continue
+		}
+
+		inc.add(ih)
+		h.Incident = inc.ID
+		inc.save()
+		return inc, false
+	}
+
+	var pending []*IncidentHost
+	for _, p := range correlator.pending {
+		if now.Sub(p.Down) <= window && p.Check != h.ID {
+			pending = append(pending, p)
+		}
+	}
+	pending = append(pending, ih)
+
+	if len(pending) < conf.Correlation.MinHosts {
+		correlator.pending = pending
+		return nil, false
+	}
+
+	correlator.pending = nil
+
+	t := getTransport("")
+
+	channel, err := t.ChannelID(t.IncomingChannel())
+	if err != nil {
+		logger.Printf("unable to open incident: %s", err)
+		return nil, false
+	}
+
+	inc = &Incident{Opened: pending[0].Down, Hosts: pending, Transport: t.Name(), Channel: channel}
+	inc.save()
+
+	correlator.incidents[inc.ID] = inc
+	h.Incident = inc.ID
+
+	// The other checks have already notified in their own threads, however
+	// they should still update the incident once they recover. They pick up
+	// the incident themselves (see Host.syncIncident), as they're owned by
+	// their own goroutines.
+
+	logger.Printf("opened incident %d with %d checks", inc.ID, len(inc.Hosts))
+	return inc, true
+}
+
+// openIncident returns the id of the open incident the host is an
+// unresolved part of, if any. Must be called with the correlator locked.
+func (h *Host) openIncident() int {
+	for id, inc := range correlator.incidents {
+		for _, ih := range inc.Hosts {
+			if ih.Check == h.ID && !ih.resolved() {
+				return id
+			}
+		}
+	}
+
+	return 0
+}
+
+// syncIncident picks up the incident the host was added to when another
+// check opened it, so it's stored with the host.
+func (h *Host) syncIncident() {
+	if h.Incident != 0 {
+		return
+	}
+
+	correlator.Lock()
+	h.Incident = h.openIncident()
+	correlator.Unlock()
+
+	if h.Incident != 0 {
+		h.save()
+	}
+}
+
+// resolveIncident marks the host as back online (or stopped) in the
+// incident it's part of, closing the incident once all checks have
+// resolved.
+func (h *Host) resolveIncident(stopped bool) {
+	if inc := h.markResolved(stopped); inc != nil {
+		inc.update()
+	}
+}
+
+// markResolved marks the host as resolved in the incident it's part of, and
+// returns the incident (nil if it isn't part of one).
+func (h *Host) markResolved(stopped bool) *Incident {
+	correlator.Lock()
+	defer correlator.Unlock()
+
+	if h.Incident == 0 {
+		// The incident may have been opened by another check, since the
+		// host last synced (see Host.syncIncident).
+		h.Incident = h.openIncident()
+	}
+
+	if h.Incident == 0 {
+		var pending []*IncidentHost
+		for _, p := range correlator.pending {
+			if p.Check != h.ID {
+				pending = append(pending, p)
+			}
+		}
+
+		correlator.pending = pending
+		return nil
+	}
+
+	inc := getIncident(h.Incident)
+	h.Incident = 0
+	if inc == nil {
+		return nil
+	}
+
+	var open int
+	for _, ih := range inc.Hosts {
+		if ih.Check == h.ID && !ih.resolved() {
+			if stopped {
+				ih.Stopped = true
+			} else {
+				ih.Up = time.Now()
+			}
+		}
+
+		if !ih.resolved() {
+			open++
+		}
+	}
+
+	if open == 0 {
+		inc.Closed = time.Now()
+		delete(correlator.incidents, inc.ID)
+		logger.Printf("closed incident %d after %s", inc.ID, inc.Duration())
+	}
+
+	inc.save()
+	return inc
+}
+
+// getIncident returns the open incident with the provided id, loading it
+// from the database if needed (e.g. after a restart). Must be called with
+// the correlator locked.
+func getIncident(id int) *Incident {
+	if inc, ok := correlator.incidents[id]; ok {
+		return inc
+	}
+
+	db := getDB()
+
+	var inc Incident
+	if err := db.From(incidentBucket).One("ID", id, &inc); err != nil {
+		logger.Printf("unable to load incident %d: %s", id, err)
+		return nil
+	}
+
+	if !inc.Closed.IsZero() {
+		return nil
+	}
+
+	correlator.incidents[id] = &inc
+	return &inc
+}
+
+// GetIncident returns the incident with the provided id.
+func GetIncident(id int) (*Incident, error) {
+	db := getDB()
+
+	var inc Incident
+	if err := db.From(incidentBucket).One("ID", id, &inc); err != nil {
+		return nil, err
+	}
+
+	return &inc, nil
+}
+
+func (inc *Incident) save() {
+	db := getDB()
+
+	if err := db.From(incidentBucket).Save(inc); err != nil {
+		logger.Printf("unable to save incident %d: %s", inc.ID, err)
+	}
+}
+
+// add adds the host to the incident. If the check is already part of it
+// (e.g. it recovered and went offline again), its entry is reopened instead.
+// Must be called with the correlator locked.
+func (inc *Incident) add(ih *IncidentHost) {
+	for _, existing := range inc.Hosts {
+		if existing.Check == ih.Check {
+			existing.Down = ih.Down
+			existing.Up = time.Time{}
+			existing.Stopped = false
+			return
+		}
+	}
+
+	inc.Hosts = append(inc.Hosts, ih)
+}
+
+// post posts the incident message in the channel of the incident. Must be
+// called without the correlator locked.
+func (inc *Incident) post() {
+	correlator.posting.Lock()
+	defer correlator.posting.Unlock()
+
+	correlator.Lock()
+	text := inc.String()
+	correlator.Unlock()
+
+	message, err := getTransport(inc.Transport).Post(inc.Channel, text)
+	if err != nil {
+		logger.Printf("unable to post incident %d: %s", inc.ID, err)
+		return
+	}
+
+	correlator.Lock()
+	inc.Message = message
+	inc.save()
+	correlator.Unlock()
+}
+
+// update updates the incident message with the current state of the
+// incident. Must be called without the correlator locked.
+func (inc *Incident) update() {
+	correlator.posting.Lock()
+	defer correlator.posting.Unlock()
+
+	correlator.Lock()
+	text, message := inc.String(), inc.Message
+	correlator.Unlock()
+
+	if message == "" {
+		return
+	}
+
+	if err := getTransport(inc.Transport).Update(inc.Channel, message, text); err != nil {
+		logger.Printf("unable to update incident %d: %s", inc.ID, err)
+	}
+}
+
+// lastDown returns when the most recent check in the incident went offline.
+func (inc *Incident) lastDown() (last time.Time) {
+	for _, ih := range inc.Hosts {
+		if ih.Down.After(last) {
+			last = ih.Down
+		}
+	}
+
+	return last
+}
+
+// Duration returns the length of the incident, up until now if it's still
+// open.
+func (inc *Incident) Duration() time.Duration {
+	if inc.Closed.IsZero() {
+		return time.Since(inc.Opened)
+	}
+
+	return inc.Closed.Sub(inc.Opened)
+}
+
+// subnet returns the /24 (or /64 for IPv6) that the address is in, used to
+// group checks within an incident.
+func subnet(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		mask := net.CIDRMask(24, 32)
+		return (&net.IPNet{IP: v4.Mask(mask), Mask: mask}).String()
+	}
+
+	mask := net.CIDRMask(64, 128)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+func (inc *Incident) String() (out string) {
+	var open int
+	groups := make(map[string][]*IncidentHost)
+	for _, ih := range inc.Hosts {
+		if !ih.resolved() {
+			open++
+		}
+
+		key := subnet(ih.Addr)
+		groups[key] = append(groups[key], ih)
+	}
+
+	if inc.Closed.IsZero() {
+		out = fmt.Sprintf(
+			":rotating_light: *incident #%d*: `%d/%d` checks offline since `%s`\n",
+			inc.ID, open, len(inc.Hosts), inc.Opened.Format(historyTimeFormat),
+		)
+	} else {
+		out = fmt.Sprintf(
+			":white_check_mark: *incident #%d* resolved after `%s` (`%d` checks affected)\n",
+			inc.ID, inc.Duration().Truncate(time.Second), len(inc.Hosts),
+		)
+	}
+
+	var keys []string
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 1 && keys[0] != "" && len(inc.Hosts) > 1 {
+		out += fmt.Sprintf("_all affected checks are within `%s`_\n", keys[0])
+	}
+
+	for _, key := range keys {
+		var states []string
+		for _, ih := range groups[key] {
+			switch {
+			case ih.Stopped:
+				states = append(states, fmt.Sprintf("`%s` stopped", ih.Target))
+			case !ih.Up.IsZero():
+				states = append(states, fmt.Sprintf("`%s` online (down `%s`)", ih.Target, ih.Up.Sub(ih.Down).Truncate(time.Second)))
+			default:
+				states = append(states, fmt.Sprintf("`%s` offline", ih.Target))
+			}
+		}
+
+		if key == "" {
+			key = "other"
+		}
+
+		out += fmt.Sprintf("> *%s*: %s\n", key, strings.Join(states, ", "))
+	}
+
+	return out
+}
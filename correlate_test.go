@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIncidentAdd(t *testing.T) {
+	down := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		hosts []*IncidentHost
+		add   string
+		want  int
+	}{
+		{nil, "web01", 1},
+		{[]*IncidentHost{{Check: "web01", Down: down}}, "web02", 2},
+		// Checks which went offline again reopen their entry.
+		{[]*IncidentHost{{Check: "web01", Down: down, Up: down}}, "web01", 1},
+		{[]*IncidentHost{{Check: "web01", Down: down, Stopped: true}, {Check: "web02", Down: down}}, "web01", 2},
+	}
+
+	for _, tt := range tests {
+		inc := &Incident{Hosts: tt.hosts}
+		inc.add(&IncidentHost{Check: tt.add, Down: time.Now()})
+
+		if len(inc.Hosts) != tt.want {
+			t.Errorf("adding %s to %d checks gave %d checks, want %d", tt.add, len(tt.hosts), len(inc.Hosts), tt.want)
+		}
+
+		for _, ih := range inc.Hosts {
+			if ih.Check == tt.add && (ih.resolved() || ih.Down.Equal(down)) {
+				t.Errorf("adding %s didn't reopen it: %+v", tt.add, ih)
+			}
+		}
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	defer func(c Config, def Transport) {
+		conf = c
+		transports.Lock()
+		transports.def = def
+		transports.Unlock()
+	}(conf, transports.def)
+
+	conf.Correlation.MinHosts = 2
+	conf.Correlation.Window.Duration = time.Minute
+
+	transports.Lock()
+	transports.def = &consoleTransport{out: ioutil.Discard, messages: make(map[string]*Message)}
+	transports.Unlock()
+
+	hosts := make(map[string]*Host)
+	for i, id := range []string{"web01", "web02", "web03"} {
+		ip := net.IPv4(10, 0, 0, byte(i+1))
+		hosts[id] = &Host{ID: id, IP: ip, Probe: &ICMPProbe{IP: ip}, Origin: &Message{Transport: "console", Channel: "ops"}}
+	}
+
+	steps := []struct {
+		check string
+		event string
+		// incident is true if the check is expected to be part of an
+		// incident, with checks (and open checks) in it.
+		incident bool
+		checks   int
+		open     int
+	}{
+		{"web01", "down", false, 0, 0},
+		{"web02", "down", true, 2, 2},
+		{"web01", "up", true, 2, 1},
+		// Going offline again doesn't add the check twice.
+		{"web01", "down", true, 2, 2},
+		{"web03", "down", true, 3, 3},
+		{"web01", "up", true, 3, 2},
+		{"web02", "up", true, 3, 1},
+		{"web03", "stop", true, 3, 0},
+	}
+
+	var inc *Incident
+	for i, step := range steps {
+		h := hosts[step.check]
+
+		switch step.event {
+		case "down":
+			if got := h.correlate(); got != step.incident {
+				t.Fatalf("step %d: %s correlate() = %t, want %t", i, step.check, got, step.incident)
+			}
+		default:
+			h.resolveIncident(step.event == "stop")
+			if h.Incident != 0 {
+				t.Errorf("step %d: %s still part of incident %d", i, step.check, h.Incident)
+			}
+		}
+
+		if !step.incident {
+			continue
+		}
+
+		correlator.Lock()
+		if inc == nil {
+			inc = correlator.incidents[h.Incident]
+		}
+
+		var open int
+		for _, ih := range inc.Hosts {
+			if !ih.resolved() {
+				open++
+			}
+		}
+
+		if len(inc.Hosts) != step.checks || open != step.open {
+			t.Errorf("step %d: %s %s gave %d checks (%d open), want %d (%d open)", i, step.check, step.event, len(inc.Hosts), open, step.checks, step.open)
+		}
+
+		_, tracked := correlator.incidents[inc.ID]
+		correlator.Unlock()
+
+		if tracked != (step.open > 0) {
+			t.Errorf("step %d: incident tracked = %t, want %t", i, tracked, step.open > 0)
+		}
+	}
+}
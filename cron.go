@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression, in the standard five field form:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields support "*" (or "?"), single values, ranges ("1-5"), steps ("*/15",
+// "0-30/5"), and lists of any of those ("1,15,30"). Day of week is 0-7, where
+// both 0 and 7 are Sunday. Like cron, if both the day of month and day of
+// week are restricted, either of them matching is sufficient.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Whether the day of month/day of week fields match every day (e.g.
+	// "*", or "*/1").
+	anyDOM, anyDOW bool
+}
+
+// parseCron parses a cron expression. See cronSchedule for the supported
+// syntax.
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &cronSchedule{}
+
+	bounds := []struct {
+		out      *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+		}
+
+		*b.out = bits
+	}
+
+	// Sunday can be either 0 or 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.anyDOM = s.dom == cronRange(1, 31)
+	s.anyDOW = s.dow&cronRange(0, 6) == cronRange(0, 6)
+
+	return s, nil
+}
+
+// cronRange returns a bitset of the values from min to max.
+func cronRange(min, max int) uint64 {
+	return (1<<uint(max+1) - 1) &^ (1<<uint(min) - 1)
+}
+
+// parseCronField parses a single field of a cron expression, returning a
+// bitset of the values it matches.
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			if start, err = strconv.Atoi(part); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			end = start
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q out of range (%d-%d)", part, min, max)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// Match returns true if the schedule matches the minute of the provided time.
+func (s *cronSchedule) Match(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.anyDOM || s.anyDOW {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// Last returns the most recent time (truncated to the minute) at or before t
+// that the schedule matched, looking back at most the provided duration.
+func (s *cronSchedule) Last(t time.Time, within time.Duration) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+
+	for since := time.Duration(0); since <= within; since += time.Minute {
+		if s.Match(t.Add(-since)) {
+			return t.Add(-since), true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestCronMatch(t *testing.T) {
+	// 2018-03-04 is a Sunday.
+	date := func(day, hour, min int) time.Time {
+		return time.Date(2018, time.March, day, hour, min, 30, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", date(4, 2, 0), true},
+		{"0 2 * * 0", date(4, 2, 0), true},
+		{"0 2 * * 7", date(4, 2, 0), true},
+		{"0 2 * * 0", date(5, 2, 0), false},
+		{"0 2 * * 0", date(4, 2, 1), false},
+		{"*/15 * * * *", date(4, 9, 45), true},
+		{"*/15 * * * *", date(4, 9, 50), false},
+		{"0-30/10 * * * *", date(4, 9, 20), true},
+		{"0-30/10 * * * *", date(4, 9, 40), false},
+		{"0 9 * * 1-5", date(5, 9, 0), true},
+		{"0 9 * * 1-5", date(10, 9, 0), false},
+		{"0 0 1,15 * *", date(15, 0, 0), true},
+		{"0 0 * 4 *", date(1, 0, 0), false},
+		// Either the day of month or day of week matching is sufficient, if
+		// both are restricted.
+		{"0 0 1 * 0", date(4, 0, 0), true},
+		{"0 0 1 * 0", date(1, 0, 0), true},
+		{"0 0 1 * 0", date(2, 0, 0), false},
+		// Fields covering every day aren't restrictions.
+		{"0 2 */1 * 1", date(5, 2, 0), true},
+		{"0 2 */1 * 1", date(6, 2, 0), false},
+		{"0 2 1-31 * 1", date(6, 2, 0), false},
+		{"0 2 ? * 1", date(6, 2, 0), false},
+		{"0 2 1 * 0-6", date(6, 2, 0), false},
+		{"0 2 1 * 0-7", date(1, 2, 0), true},
+		{"0 2 1 * ?", date(6, 2, 0), false},
+		{"0 2 1 * 1-7", date(1, 2, 0), true},
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q) returned error: %s", tt.spec, err)
+			continue
+		}
+
+		if got := s.Match(tt.t); got != tt.want {
+			t.Errorf("%q.Match(%s) = %t, want %t", tt.spec, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestCronLast(t *testing.T) {
+	s, err := parseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2018, time.March, 4, 2, 45, 10, 0, time.UTC)
+
+	last, ok := s.Last(now, time.Hour)
+	if want := time.Date(2018, time.March, 4, 2, 0, 0, 0, time.UTC); !ok || !last.Equal(want) {
+		t.Errorf("Last(%s, 1h) = %s, %t, want %s", now, last, ok, want)
+	}
+
+	if _, ok = s.Last(now, 30*time.Minute); ok {
+		t.Errorf("Last(%s, 30m) matched, want no match", now)
+	}
+}
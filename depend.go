@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dependencyOptions are the options which control what a check depends on
+// (see Host.parent).
+var dependencyOptions = []string{"via"}
+
+// parent returns the query of the check the host depends on (e.g. the router
+// in front of it), from the "via" option, or the configured parents. Returns
+// an empty string if the host doesn't depend on anything.
+func (h *Host) parent() string {
+	if via := h.Options.String("via", ""); via != "" {
+		return via
+	}
+
+	var keys []string
+	for key := range conf.Parents {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if h.matches(key) && !h.matches(conf.Parents[key]) {
+			return conf.Parents[key]
+		}
+	}
+
+	return ""
+}
+
+// Find returns the check matching the provided query, ip or target exactly.
+func (h *Hosts) Find(query string) *Host {
+	h.Lock()
+	defer h.Unlock()
+
+	query = strings.ToLower(query)
+
+	for key, host := range h.inv {
+		if key == query || strings.ToLower(host.Probe.Target()) == query || (host.IP != nil && host.IP.String() == query) {
+			return host
+		}
+	}
+
+	return nil
+}
+
+// parentDown returns true if the parent of the host is offline. If the
+// parent is being watched and is already known to be offline, that's used,
+// otherwise it is probed once (as a watched parent may not have finished its
+// own round of probes yet). The probe of a watched parent isn't reused, as
+// it's only safe to run from the goroutine watching it.
+func (h *Host) parentDown(parent string) bool {
+	query, opts := parent, Options(nil)
+	if p := hostGroup.Find(parent); p != nil && p != h {
+		if !p.isOnline() {
+			return true
+		}
+
+		query, opts = p.Query, p.Options
+	}
+
+	probe, _, err := newProbe(query, opts)
+	if err != nil {
+		// Unable to resolve the parent, which likely means it is unreachable
+		// as well.
+		logger.Printf("unable to probe parent %q of %s: %s", parent, h.Probe.Target(), err)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return !probe.Run(ctx).Online()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParentDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	up, down := ln.Addr().String(), closed.Addr().String()
+
+	tests := []struct {
+		name   string
+		parent string
+		// watched is set if the parent is being watched, with its state.
+		watched *bool
+		want    bool
+	}{
+		{"unwatched online", up, nil, false},
+		{"unwatched offline", down, nil, true},
+		{"unresolvable", "invalid host!", nil, true},
+		{"watched offline", up, newBool(false), true},
+		{"watched online, not yet noticed offline", down, newBool(true), true},
+		{"watched online", up, newBool(true), false},
+	}
+
+	child := &Host{ID: "child", Probe: &TCPProbe{Addr: "127.0.0.1:1"}}
+
+	for _, tt := range tests {
+		if tt.watched != nil {
+			probe, _, err := newProbe(tt.parent, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hostGroup.Lock()
+			hostGroup.inv[tt.parent] = &Host{ID: tt.parent, Query: tt.parent, Probe: probe, Online: *tt.watched}
+			hostGroup.Unlock()
+		}
+
+		if got := child.parentDown(tt.parent); got != tt.want {
+			t.Errorf("%s: parentDown(%q) = %t, want %t", tt.name, tt.parent, got, tt.want)
+		}
+
+		hostGroup.Lock()
+		delete(hostGroup.inv, tt.parent)
+		hostGroup.Unlock()
+	}
+}
+
+func newBool(b bool) *bool { return &b }
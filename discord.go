@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	discordAPI = "https://discord.com/api/v10"
+	// discordMaxLength is the maximum length of a single message.
+	discordMaxLength = 2000
+
+	// discordIntents are the gateway intents which are requested: guilds,
+	// guild messages, guild message reactions, direct messages, direct
+	// message reactions and message content.
+	discordIntents = 1<<0 | 1<<9 | 1<<10 | 1<<12 | 1<<13 | 1<<15
+)
+
+// Gateway opcodes.
+const (
+	discordOpDispatch       = 0
+	discordOpHeartbeat      = 1
+	discordOpIdentify       = 2
+	discordOpResume         = 6
+	discordOpReconnect      = 7
+	discordOpInvalidSession = 9
+	discordOpHello          = 10
+	discordOpHeartbeatAck   = 11
+)
+
+// Channel types.
+const (
+	discordChannelDM      = 1
+	discordChannelGroupDM = 3
+)
+
+// discordErrThreadExists is returned when a thread has already been created
+// for a message.
+const discordErrThreadExists = 160004
+
+// discordTransport is a Discord transport, using the gateway for events and
+// the rest api for everything else. Reactions to messages start checks, and
+// updates are sent in a thread created from the message. Threads created
+// from a message share its id, so the id of the original message is used as
+// the thread id.
+type discordTransport struct {
+	client *http.Client
+
+	// wmu serializes writes to the gateway connection.
+	wmu sync.Mutex
+	// events are messages and reactions waiting to be handled. They're
+	// handled outside of the gateway read loop, so slow commands don't
+	// delay heartbeats.
+	events chan func()
+
+	mu sync.Mutex
+	// Session state, used to resume the session after reconnecting.
+	sessionID string
+	resumeURL string
+	seq       int64
+	botID     string
+	restarted bool
+
+	channels map[string]*discordChannel
+	// names caches channel names (without the "#") to ids.
+	names map[string]string
+	users map[string]string
+	// threads tracks messages which threads have been created from.
+	threads map[string]bool
+}
+
+func newDiscordTransport() *discordTransport {
+	return &discordTransport{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		events:   make(chan func(), 100),
+		channels: make(map[string]*discordChannel),
+		names:    make(map[string]string),
+		users:    make(map[string]string),
+		threads:  make(map[string]bool),
+	}
+}
+
+// discordError is an error returned by the rest api.
+type discordError struct {
+	Status  int
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *discordError) Error() string {
+	return fmt.Sprintf("%s (code %d, status %d)", e.Message, e.Code, e.Status)
+}
+
+type discordPayload struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d"`
+	Seq  int64           `json:"s"`
+	Type string          `json:"t"`
+}
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type discordMessage struct {
+	ID        string      `json:"id"`
+	ChannelID string      `json:"channel_id"`
+	Author    discordUser `json:"author"`
+	Content   string      `json:"content"`
+}
+
+type discordReaction struct {
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+	MessageID string `json:"message_id"`
+	Emoji     struct {
+		Name string `json:"name"`
+	} `json:"emoji"`
+}
+
+type discordChannel struct {
+	ID       string `json:"id"`
+	Type     int    `json:"type"`
+	Name     string `json:"name"`
+	GuildID  string `json:"guild_id"`
+	ParentID string `json:"parent_id"`
+	// ThreadMetadata is only set for threads.
+	ThreadMetadata *struct {
+		Archived bool `json:"archived"`
+	} `json:"thread_metadata"`
+}
+
+func (d *discordTransport) Name() string            { return "discord" }
+func (d *discordTransport) IncomingChannel() string { return conf.Discord.IncomingChannel }
+
+// do sends a request to the rest api, decoding the response into out (if not
+// nil). Rate limited requests are retried.
+func (d *discordTransport) do(method, path string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, discordAPI+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bot "+conf.Discord.Token)
+		req.Header.Set("User-Agent", "DiscordBot (https://github.com/lrstanley/ponger, 1.0)")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		respData, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			var limit struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			json.Unmarshal(respData, &limit)
+
+			time.Sleep(time.Duration(limit.RetryAfter*float64(time.Second)) + 100*time.Millisecond)
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			derr := &discordError{Status: resp.StatusCode}
+			if err = json.Unmarshal(respData, derr); err != nil || derr.Message == "" {
+				derr.Message = http.StatusText(resp.StatusCode)
+			}
+
+			return derr
+		}
+
+		if out == nil || resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+
+		return json.Unmarshal(respData, out)
+	}
+}
+
+// discordFatalClose returns true if the gateway was closed with a code that
+// reconnecting won't fix (e.g. invalid token or intents).
+func discordFatalClose(code int) bool {
+	return code == 4004 || (code >= 4010 && code <= 4014)
+}
+
+func (d *discordTransport) Run(handler EventHandler) error {
+	if conf.Discord.Token == "" || d.IncomingChannel() == "" {
+		return errors.New("token and incoming channel must be configured")
+	}
+
+	go func() {
+		for fn := range d.events {
+			fn()
+		}
+	}()
+
+	var failures int
+	for {
+		start := time.Now()
+		err := d.connect(handler)
+
+		if cerr, ok := err.(*websocket.CloseError); ok && discordFatalClose(cerr.Code) {
+			return err
+		}
+		if derr, ok := err.(*discordError); ok && derr.Status == http.StatusUnauthorized {
+			return err
+		}
+
+		// Only back off if the connection didn't last, so requested
+		// reconnects are resumed immediately.
+		if time.Since(start) > 5*time.Minute {
+			failures = 0
+		}
+
+		delay := time.Duration(failures*failures) * 5 * time.Second
+		if delay > 2*time.Minute {
+			delay = 2 * time.Minute
+		}
+		failures++
+
+		logger.Printf("discord gateway connection lost: %s (reconnecting in %s)", err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// write sends a payload to the gateway.
+func (d *discordTransport) write(conn *websocket.Conn, op int, data interface{}) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	return conn.WriteJSON(map[string]interface{}{"op": op, "d": data})
+}
+
+// heartbeat sends a heartbeat to the gateway. If the previous heartbeat was
+// never acknowledged, the connection is assumed to be dead, and is closed so
+// it can be resumed.
+func (d *discordTransport) heartbeat(conn *websocket.Conn, interval time.Duration, acked *int32, done chan struct{}) {
+	// The first heartbeat is jittered, as requested by the gateway.
+	timer := time.NewTimer(time.Duration(rand.Float64() * float64(interval)))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		if !atomic.CompareAndSwapInt32(acked, 1, 0) {
+			logger.Printf("discord heartbeat not acknowledged, reconnecting")
+			conn.Close()
+			return
+		}
+
+		if err := d.sendHeartbeat(conn); err != nil {
+			conn.Close()
+			return
+		}
+
+		timer.Reset(interval)
+	}
+}
+
+func (d *discordTransport) sendHeartbeat(conn *websocket.Conn) error {
+	d.mu.Lock()
+	seq := d.seq
+	d.mu.Unlock()
+
+	if seq == 0 {
+		return d.write(conn, discordOpHeartbeat, nil)
+	}
+
+	return d.write(conn, discordOpHeartbeat, seq)
+}
+
+// connect connects to the gateway (resuming the previous session if
+// possible), and handles events until the connection fails.
+func (d *discordTransport) connect(handler EventHandler) error {
+	d.mu.Lock()
+	gateway, sessionID, seq := d.resumeURL, d.sessionID, d.seq
+	d.mu.Unlock()
+
+	if sessionID == "" || gateway == "" {
+		var resp struct {
+			URL string `json:"url"`
+		}
+
+		if err := d.do(http.MethodGet, "/gateway/bot", nil, &resp); err != nil {
+			return err
+		}
+		gateway = resp.URL
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.TrimSuffix(gateway, "/")+"/?v=10&encoding=json", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var hello discordPayload
+	conn.SetReadDeadline(time.Now().Add(time.Minute))
+	if err = conn.ReadJSON(&hello); err != nil {
+		return err
+	}
+
+	if hello.Op != discordOpHello {
+		return fmt.Errorf("expected hello from gateway, got op %d", hello.Op)
+	}
+
+	var helloData struct {
+		HeartbeatInterval int `json:"heartbeat_interval"`
+	}
+	if err = json.Unmarshal(hello.Data, &helloData); err != nil {
+		return err
+	}
+	interval := time.Duration(helloData.HeartbeatInterval) * time.Millisecond
+
+	if sessionID != "" {
+		err = d.write(conn, discordOpResume, map[string]interface{}{
+			"token":      conf.Discord.Token,
+			"session_id": sessionID,
+			"seq":        seq,
+		})
+	} else {
+		err = d.write(conn, discordOpIdentify, map[string]interface{}{
+			"token":   conf.Discord.Token,
+			"intents": discordIntents,
+			"properties": map[string]string{
+				"os":      "linux",
+				"browser": "ponger",
+				"device":  "ponger",
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	acked := int32(1)
+	done := make(chan struct{})
+	defer close(done)
+	go d.heartbeat(conn, interval, &acked, done)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * interval))
+
+		var payload discordPayload
+		if err = conn.ReadJSON(&payload); err != nil {
+			if cerr, ok := err.(*websocket.CloseError); ok && (cerr.Code == 4007 || cerr.Code == 4009) {
+				// Invalid sequence, or the session timed out.
+				d.clearSession()
+			}
+
+			return err
+		}
+
+		if payload.Seq > 0 {
+			d.mu.Lock()
+			d.seq = payload.Seq
+			d.mu.Unlock()
+		}
+
+		switch payload.Op {
+		case discordOpDispatch:
+			d.dispatch(payload.Type, payload.Data, handler)
+		case discordOpHeartbeat:
+			if err = d.sendHeartbeat(conn); err != nil {
+				return err
+			}
+		case discordOpHeartbeatAck:
+			atomic.StoreInt32(&acked, 1)
+		case discordOpReconnect:
+			return errors.New("reconnect requested by gateway")
+		case discordOpInvalidSession:
+			var resumable bool
+			json.Unmarshal(payload.Data, &resumable)
+			if !resumable {
+				d.clearSession()
+			}
+
+			time.Sleep(time.Duration(1+rand.Intn(5)) * time.Second)
+			return errors.New("invalid session")
+		}
+	}
+}
+
+// clearSession forgets the current session, so the next connection
+// identifies rather than resuming.
+func (d *discordTransport) clearSession() {
+	d.mu.Lock()
+	d.sessionID, d.resumeURL, d.seq = "", "", 0
+	d.mu.Unlock()
+}
+
+// dispatch handles a gateway event.
+func (d *discordTransport) dispatch(event string, data json.RawMessage, handler EventHandler) {
+	if flags.Debug {
+		logger.Printf("discord: %s: %s", event, data)
+	}
+
+	switch event {
+	case "READY":
+		var ready struct {
+			SessionID string      `json:"session_id"`
+			ResumeURL string      `json:"resume_gateway_url"`
+			User      discordUser `json:"user"`
+		}
+		if err := json.Unmarshal(data, &ready); err != nil {
+			logger.Printf("unable to decode discord ready event: %s", err)
+			return
+		}
+
+		d.mu.Lock()
+		d.sessionID, d.resumeURL, d.botID = ready.SessionID, ready.ResumeURL, ready.User.ID
+		restarted := d.restarted
+		d.restarted = true
+		d.mu.Unlock()
+
+		logger.Printf("connected to discord as %s", ready.User.Username)
+
+		if !restarted {
+			go func() {
+				channel, err := d.ChannelID(d.IncomingChannel())
+				if err == nil {
+					_, err = d.Post(channel, restartMessage())
+				}
+
+				if err != nil {
+					logger.Printf("unable to send restart message: %s", err)
+				}
+			}()
+		}
+	case "RESUMED":
+		logger.Printf("resumed discord session")
+	case "MESSAGE_CREATE":
+		var msg discordMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Author.ID == d.self() {
+			return
+		}
+
+		d.events <- func() { handler(d, &Event{Message: d.message(&msg)}) }
+	case "MESSAGE_REACTION_ADD", "MESSAGE_REACTION_REMOVE":
+		var reaction discordReaction
+		if err := json.Unmarshal(data, &reaction); err != nil || !d.isTrigger(reaction.Emoji.Name) || reaction.UserID == d.self() {
+			return
+		}
+
+		d.events <- func() { d.reaction(event, &reaction, handler) }
+	}
+}
+
+// reaction handles a reaction to a message, which is fetched as it's not
+// included in the event.
+func (d *discordTransport) reaction(event string, reaction *discordReaction, handler EventHandler) {
+	var msg discordMessage
+	if err := d.do(http.MethodGet, "/channels/"+reaction.ChannelID+"/messages/"+reaction.MessageID, nil, &msg); err != nil {
+		logger.Printf("unable to fetch %s in %s: %s", reaction.MessageID, reaction.ChannelID, err)
+		return
+	}
+
+	if msg.Author.ID == d.self() {
+		return
+	}
+
+	handler(d, &Event{
+		Message: d.message(&msg), Reaction: reaction.Emoji.Name,
+		ReactionUser: reaction.UserID, ReactionRemoved: event == "MESSAGE_REACTION_REMOVE",
+	})
+}
+
+// self returns the user id of the bot.
+func (d *discordTransport) self() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.botID
+}
+
+// isTrigger returns true if the reaction emoji matches the configured
+// reaction trigger (either the emoji itself, or the name of a custom emoji).
+func (d *discordTransport) isTrigger(emoji string) bool {
+	trigger := reactionEmoji(conf.Discord.ReactionTrigger)
+
+	return emoji == trigger || ":"+emoji+":" == trigger
+}
+
+// message converts a Discord message into a Message. Messages in threads
+// use the parent channel, with the thread as the thread id.
+func (d *discordTransport) message(msg *discordMessage) *Message {
+	d.mu.Lock()
+	d.users[msg.Author.ID] = msg.Author.Username
+	d.mu.Unlock()
+
+	m := &Message{
+		Transport: d.Name(),
+		Channel:   msg.ChannelID,
+		User:      msg.Author.ID,
+		Text:      msg.Content,
+		Timestamp: msg.ID,
+	}
+
+	if ch := d.channel(msg.ChannelID); ch != nil && ch.ThreadMetadata != nil {
+		m.Channel = ch.ParentID
+		m.ThreadTimestamp = msg.ChannelID
+	}
+
+	return m
+}
+
+// channel returns the provided channel (or thread), or nil if it can't be
+// fetched.
+func (d *discordTransport) channel(id string) *discordChannel {
+	d.mu.Lock()
+	ch, ok := d.channels[id]
+	d.mu.Unlock()
+	if ok {
+		return ch
+	}
+
+	ch = &discordChannel{}
+	if err := d.do(http.MethodGet, "/channels/"+id, nil, ch); err != nil {
+		logger.Printf("unable to fetch discord channel %s: %s", id, err)
+		return nil
+	}
+
+	d.mu.Lock()
+	d.channels[id] = ch
+	d.mu.Unlock()
+
+	return ch
+}
+
+// thread returns the thread to reply to msg in, creating it from the message
+// if needed. Direct messages don't support threads, so the channel is used.
+func (d *discordTransport) thread(msg *Message) (string, error) {
+	if msg.ThreadTimestamp != "" {
+		return msg.ThreadTimestamp, nil
+	}
+
+	if ch := d.channel(msg.Channel); ch == nil || ch.Type == discordChannelDM || ch.Type == discordChannelGroupDM {
+		return msg.Channel, nil
+	}
+
+	d.mu.Lock()
+	exists := d.threads[msg.Timestamp]
+	d.mu.Unlock()
+	if exists {
+		return msg.Timestamp, nil
+	}
+
+	err := d.do(http.MethodPost, "/channels/"+msg.Channel+"/messages/"+msg.Timestamp+"/threads", map[string]interface{}{
+		"name":                  discordThreadName(msg.Text),
+		"auto_archive_duration": 1440,
+	}, nil)
+	if derr, ok := err.(*discordError); ok && derr.Code == discordErrThreadExists {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	d.threads[msg.Timestamp] = true
+	d.mu.Unlock()
+
+	return msg.Timestamp, nil
+}
+
+// discordThreadName returns the name of a thread created from a message with
+// the provided text.
+func discordThreadName(text string) string {
+	name := strings.TrimSpace(strings.SplitN(text, "\n", 2)[0])
+	if name == "" {
+		return "ponger"
+	}
+
+	if r := []rune(name); len(r) > 90 {
+		name = string(r[:90]) + "..."
+	}
+
+	return "ponger: " + name
+}
+
+// reDiscordBold matches Slack bold text, which uses single asterisks.
+var reDiscordBold = regexp.MustCompile(`(^|\s)\*([^*\n]+)\*`)
+
+// discordFormat converts Slack formatted text for Discord. Most formatting is
+// the same, other than bold text and emoji shortcodes.
+func discordFormat(text string) string {
+	text = replaceShortcodes(text)
+
+	// Code blocks are replaced with placeholders, so their contents aren't
+	// formatted.
+	var blocks []string
+	text = reCodeBlock.ReplaceAllStringFunc(text, func(block string) string {
+		blocks = append(blocks, block)
+		return fmt.Sprintf("\x00%d\x00", len(blocks)-1)
+	})
+
+	text = reDiscordBold.ReplaceAllString(text, "$1**$2**")
+
+	for i, block := range blocks {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), block, 1)
+	}
+
+	return text
+}
+
+// discordSplit splits text into messages no longer than the maximum length,
+// splitting on lines where possible.
+func discordSplit(text string) (parts []string) {
+	for len(text) > discordMaxLength {
+		i := strings.LastIndex(text[:discordMaxLength], "\n")
+		if i <= 0 {
+			i = discordMaxLength
+		}
+
+		parts = append(parts, text[:i])
+		text = strings.TrimPrefix(text[i:], "\n")
+	}
+
+	return append(parts, text)
+}
+
+// send sends text to a channel, returning the id of the first message.
+func (d *discordTransport) send(channel, text string) (id string, err error) {
+	for _, part := range discordSplit(discordFormat(text)) {
+		var msg discordMessage
+
+		err = d.do(http.MethodPost, "/channels/"+channel+"/messages", map[string]interface{}{
+			"content":          part,
+			"allowed_mentions": map[string][]string{"parse": {"users"}},
+		}, &msg)
+		if err != nil {
+			return id, err
+		}
+
+		if id == "" {
+			id = msg.ID
+		}
+	}
+
+	return id, nil
+}
+
+func (d *discordTransport) Reply(msg *Message, thread bool, text string) error {
+	channel := msg.Channel
+	if thread {
+		var err error
+		if channel, err = d.thread(msg); err != nil {
+			return err
+		}
+	}
+
+	_, err := d.send(channel, text)
+	return err
+}
+
+func (d *discordTransport) Post(channel, text string) (string, error) {
+	return d.send(channel, text)
+}
+
+func (d *discordTransport) Update(channel, id, text string) error {
+	text = discordSplit(discordFormat(text))[0]
+
+	return d.do(http.MethodPatch, "/channels/"+channel+"/messages/"+id, map[string]string{"content": text}, nil)
+}
+
+// ChannelID returns the id of the provided channel name (e.g. "#ops"), from
+// the guilds the bot is in (or only the configured guild). Channel ids are
+// returned as-is.
+func (d *discordTransport) ChannelID(name string) (string, error) {
+	if _, err := strconv.ParseUint(name, 10, 64); err == nil {
+		return name, nil
+	}
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+
+	d.mu.Lock()
+	id, ok := d.names[name]
+	d.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	guilds := []discordChannel{{ID: conf.Discord.Guild}}
+	if conf.Discord.Guild == "" {
+		if err := d.do(http.MethodGet, "/users/@me/guilds", nil, &guilds); err != nil {
+			return "", err
+		}
+	}
+
+	for _, guild := range guilds {
+		var channels []*discordChannel
+		if err := d.do(http.MethodGet, "/guilds/"+guild.ID+"/channels", nil, &channels); err != nil {
+			return "", err
+		}
+
+		d.mu.Lock()
+		for _, ch := range channels {
+			d.channels[ch.ID] = ch
+			if _, ok := d.names[strings.ToLower(ch.Name)]; !ok {
+				d.names[strings.ToLower(ch.Name)] = ch.ID
+			}
+		}
+		id, ok = d.names[name]
+		d.mu.Unlock()
+
+		if ok {
+			return id, nil
+		}
+	}
+
+	return "", errors.New("channel not found")
+}
+
+// ChannelName returns the name of the provided channel (e.g. "#ops"). The
+// incoming channel is always returned as configured, so it can be compared
+// against.
+func (d *discordTransport) ChannelName(id string) string {
+	if incoming, err := d.ChannelID(d.IncomingChannel()); err == nil && incoming == id {
+		return d.IncomingChannel()
+	}
+
+	ch := d.channel(id)
+	if ch == nil {
+		return id
+	}
+
+	if ch.Type == discordChannelDM || ch.Type == discordChannelGroupDM {
+		return ""
+	}
+
+	return "#" + ch.Name
+}
+
+func (d *discordTransport) UserName(id string) string {
+	d.mu.Lock()
+	name, ok := d.users[id]
+	d.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	var user discordUser
+	if err := d.do(http.MethodGet, "/users/"+id, nil, &user); err != nil || user.Username == "" {
+		return id
+	}
+
+	d.mu.Lock()
+	d.users[id] = user.Username
+	d.mu.Unlock()
+
+	return user.Username
+}
+
+func (d *discordTransport) Mention(id string) string { return "<@" + id + ">" }
+
+func (d *discordTransport) Permalink(channel, id string) string {
+	if channel == "" || id == "" {
+		return ""
+	}
+
+	guild := "@me"
+	if ch := d.channel(channel); ch != nil && ch.GuildID != "" {
+		guild = ch.GuildID
+	}
+
+	return "https://discord.com/channels/" + guild + "/" + channel + "/" + id
+}
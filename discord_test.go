@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestDiscordIsTrigger(t *testing.T) {
+	defer func(trigger, discordTrigger string) {
+		conf.ReactionTrigger, conf.Discord.ReactionTrigger = trigger, discordTrigger
+	}(conf.ReactionTrigger, conf.Discord.ReactionTrigger)
+
+	tests := []struct {
+		trigger        string
+		discordTrigger string
+		emoji          string
+		want           bool
+	}{
+		// Custom Slack emoji don't exist in Discord.
+		{"ponger", "", "🏓", true},
+		{"ponger", "", "ponger", false},
+		{"ponger", "", "👍", false},
+		// Known shortcodes are mapped to their emoji.
+		{"table_tennis_paddle_and_ball", "", "🏓", true},
+		{":warning:", "", "⚠️", true},
+		{"ponger", "👀", "👀", true},
+		{"ponger", "👀", "🏓", false},
+		{"ponger", ":ping_pong:", "🏓", true},
+		// Custom Discord emoji are matched by name.
+		{"ponger", "pong", "pong", true},
+		{"ponger", ":pong:", "pong", true},
+		{"ponger", "pong", "🏓", false},
+	}
+
+	d := newDiscordTransport()
+	for _, tt := range tests {
+		conf.ReactionTrigger, conf.Discord.ReactionTrigger = tt.trigger, tt.discordTrigger
+
+		if got := d.isTrigger(tt.emoji); got != tt.want {
+			t.Errorf("isTrigger(%q) with triggers %q/%q = %t, want %t", tt.emoji, tt.trigger, tt.discordTrigger, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import "time"
+
+// flap tracks a state transition of the host, and returns true if the
+// transition notification should be suppressed, as the host is flapping.
+// A single notification is sent when the host starts flapping, with digests
+// sent periodically (see checkFlapping) until it stabilizes.
+func (h *Host) flap() (suppress bool) {
+	if conf.Flap.Threshold < 1 {
+		return false
+	}
+
+	now := time.Now()
+	h.Transitions = append(h.Transitions, now)
+
+	// Only keep the transitions which are within the window.
+	for len(h.Transitions) > 0 && now.Sub(h.Transitions[0]) > conf.Flap.Window.Duration {
+		h.Transitions = h.Transitions[1:]
+	}
+
+	if h.Flapping {
+		h.FlapCount++
+		return true
+	}
+
+	if len(h.Transitions) < conf.Flap.Threshold {
+		return false
+	}
+
+	h.Flapping = true
+	h.FlapStart = now
+	h.FlapCount = len(h.Transitions)
+	h.LastDigest = now
+
+	h.Sendf(
+		"%s is flapping (`%d` state changes in the last `%s`), suppressing updates until it stabilizes :warn1:",
+		h.Probe.Target(), len(h.Transitions), conf.Flap.Window.Duration,
+	)
+	h.record(eventFlapping, "", nil)
+	return true
+}
+
+// checkFlapping sends a periodic digest while the host is flapping, and
+// notifies once it has stabilized.
+func (h *Host) checkFlapping() {
+	if !h.Flapping {
+		return
+	}
+
+	state := "offline :warn1:"
+	if h.Online {
+		state = "online :white_check_mark:"
+	}
+
+	var last time.Time
+	if len(h.Transitions) > 0 {
+		last = h.Transitions[len(h.Transitions)-1]
+	}
+
+	if time.Since(last) >= conf.Flap.Stable.Duration {
+		h.Flapping = false
+		h.Transitions = nil
+
+		h.Sendf(
+			"%s has stabilized after flapping for `%s` (`%d` state changes, downtime: `%s`), now %s",
+			h.Probe.Target(), last.Sub(h.FlapStart).Truncate(time.Second), h.FlapCount,
+			h.TotalDowntime.Truncate(time.Second), state,
+		)
+		h.record(eventStable, "", nil)
+		return
+	}
+
+	if time.Since(h.LastDigest) >= conf.Flap.DigestInterval.Duration {
+		h.LastDigest = time.Now()
+
+		h.Sendf(
+			"%s still flapping (`%d` state changes in the last `%s`), currently %s",
+			h.Probe.Target(), h.FlapCount, time.Since(h.FlapStart).Truncate(time.Second), state,
+		)
+	}
+}
@@ -6,30 +6,31 @@ import (
 	"regexp"
 	"strings"
 	"time"
-
-	"github.com/nlopes/slack"
 )
 
-var reIP = regexp.MustCompile(`\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`)
-var reHostname = regexp.MustCompile(`(?m)(?:^| )((?:(?:[a-zA-Z]{1})|(?:[a-zA-Z]{1}[a-zA-Z]{1})|(?:[a-zA-Z]{1}[0-9]{1})|(?:[0-9]{1}[a-zA-Z]{1})|(?:[a-zA-Z0-9][a-zA-Z0-9-_.]{1,61}[a-zA-Z0-9]))\.(?:[a-zA-Z]{2,6}|[a-zA-Z0-9-]{2,30}\.[a-zA-Z]{2,3}))(?: |$)`)
-var reUnlink = regexp.MustCompile(`<http[^\|]+\|([^>]+)>`)
+var reIP = regexp.MustCompile(`\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}(?::\d{1,5})?`)
 
-func msgHandler(ev interface{}, msg *slack.Message, remove bool, botID string, reaction string) {
-	if msg.User == botID || msg.Text == "" {
-		logger.Printf("ignoring %s:%s: from bot or empty text", msg.Channel, msg.User)
+// reIPv6 is intentionally loose (it will match things like timestamps), so
+// matches must be validated with net.ParseIP.
+var reIPv6 = regexp.MustCompile(`\[[0-9a-fA-F:]+\](?::\d{1,5})?|[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}`)
+var reHostname = regexp.MustCompile(`(?m)(?:^| )((?:(?:[a-zA-Z]{1})|(?:[a-zA-Z]{1}[a-zA-Z]{1})|(?:[a-zA-Z]{1}[0-9]{1})|(?:[0-9]{1}[a-zA-Z]{1})|(?:[a-zA-Z0-9][a-zA-Z0-9-_.]{1,61}[a-zA-Z0-9]))\.(?:[a-zA-Z]{2,6}|[a-zA-Z0-9-]{2,30}\.[a-zA-Z]{2,3})(?::[0-9]{1,5})?)(?: |$)`)
+var reUnlink = regexp.MustCompile(`<http[^\|]+\|([^>]+)>`)
+var reBareLink = regexp.MustCompile(`<(https?://[^\|>]+)>`)
+var reURL = regexp.MustCompile(`\bhttps?://[^\s<>|]+`)
+
+// msgHandler handles messages and reactions from all transports. Transports
+// are expected to filter out messages sent by the bot itself.
+func msgHandler(t Transport, ev *Event) {
+	msg, reaction, remove := ev.Message, ev.Reaction, ev.ReactionRemoved
+	if msg.Text == "" {
+		logger.Printf("ignoring %s:%s:%s: empty text", t.Name(), msg.Channel, msg.User)
 		return
 	}
 
-	defer catchPanic(msg)
+	defer catchPanic(t, msg)
 
-	var reactionUser string
+	reactionUser := ev.ReactionUser
 	if reaction != "" {
-		if radded, ok := ev.(*slack.ReactionAddedEvent); ok {
-			reactionUser = radded.User
-		} else if rdel, ok := ev.(*slack.ReactionRemovedEvent); ok {
-			reactionUser = rdel.User
-		}
-
 		if reactionUser == "" {
 			logger.Printf("skipping add/remove of reaction %s: user not found", reaction)
 			return
@@ -50,20 +51,21 @@ func msgHandler(ev interface{}, msg *slack.Message, remove bool, botID string, r
 		}
 	}
 
-	channelName := slackIDToChannel(msg.Channel)
+	channelName := t.ChannelName(msg.Channel)
 
-	if _, ok := ev.(*slack.MessageEvent); ok {
-		logger.Printf("<%s[%s]:%s> %s", msg.Channel, channelName, msg.User, msg.Text)
+	if reaction == "" {
+		logger.Printf("<%s:%s[%s]:%s> %s", t.Name(), msg.Channel, channelName, msg.User, msg.Text)
 	}
 
 	msg.Text = reUnlink.ReplaceAllString(msg.Text, "$1")
+	msg.Text = reBareLink.ReplaceAllString(msg.Text, "$1")
 
 	cmd := reCommand.FindStringSubmatch(msg.Text)
 	// Only parse it as a command, if it's not a reaction based message.
 	if reaction == "" && len(cmd) == 3 && cmd[1] != "" {
 		cmd[1] = strings.ToLower(cmd[1])
 		// Allow some commands even if it's not in the incoming channel.
-		if strings.ToLower(channelName) != strings.ToLower(conf.IncomingChannel) && channelName != "" {
+		if strings.ToLower(channelName) != strings.ToLower(t.IncomingChannel()) && channelName != "" {
 			if (cmd[1] == "help" || cmd[1] == "halp") && msg.ThreadTimestamp == "" {
 				return
 			}
@@ -76,7 +78,7 @@ func msgHandler(ev interface{}, msg *slack.Message, remove bool, botID string, r
 		return
 	}
 
-	if reaction == "" && strings.ToLower(channelName) != strings.ToLower(conf.IncomingChannel) && channelName != "" {
+	if reaction == "" && strings.ToLower(channelName) != strings.ToLower(t.IncomingChannel()) && channelName != "" {
 		logger.Printf("skipping: %q not input channel or PM, and not reaction", channelName)
 		return
 	}
@@ -87,92 +89,108 @@ func msgHandler(ev interface{}, msg *slack.Message, remove bool, botID string, r
 		return
 	}
 
-	ips := reIP.FindAllString(msg.Text, -1)
+	// Check for urls first, removing them from the text so the hosts
+	// within them aren't also picked up as ips/hostnames.
+	for _, query := range reURL.FindAllString(msg.Text, -1) {
+		watchQuery(t, ev, channelName, strings.Replace(query, "&amp;", "&", -1), true)
+	}
+	text := reURL.ReplaceAllString(msg.Text, "")
+
+	ips := append(reIP.FindAllString(text, -1), reIPv6.FindAllString(text, -1)...)
 	if len(ips) == 0 {
 		// Check for hostnames.
-		hosts := reHostname.FindAllStringSubmatch(msg.Text, -1)
+		hosts := reHostname.FindAllStringSubmatch(text, -1)
 
 		if hosts == nil {
 			return
 		}
 
 		for i := 0; i < len(hosts); i++ {
-			addrs, err := net.LookupIP(hosts[i][1])
-			if err != nil {
-				continue
-			}
+			watchQuery(t, ev, channelName, hosts[i][1], true)
+		}
 
-			if ok, buffer := hostGroup.Exists(addrs[0].String()); ok {
-				// Convert the reaction into a message, essentially, allowing
-				// us to respond directly to them.
-				if radded, ok := ev.(*slack.ReactionAddedEvent); ok {
-					msg = slackRefToMessage(radded.Item.Channel, radded.User, radded.Item.Timestamp)
-				} else if rdel, ok := ev.(*slack.ReactionRemovedEvent); ok {
-					msg = slackRefToMessage(rdel.Item.Channel, rdel.User, rdel.Item.Timestamp)
-				}
-				slackReply(msg, true, fmt.Sprintf("<@%s>: %s already monitored, ignoring (%s)", msg.User, addrs[0].String(), buffer))
-				continue
-			}
+		return
+	}
 
-			host := &Host{
-				closer:         make(chan struct{}, 1),
-				Origin:         msg,
-				IP:             addrs[0],
-				Added:          time.Now(),
-				Buffer:         channelName,
-				OriginReaction: reaction,
-				Highlight:      []string{},
-			}
+	// We should loop through each IP (and optionally port) we find and
+	// track it.
+	for _, query := range ips {
+		// Make sure it's a valid ip.
+		addr, _ := splitHostPort(query)
+		if ip := net.ParseIP(addr); ip == nil || ip.IsUnspecified() {
+			continue
+		}
 
-			if reaction != "" {
-				host.Buffer = "via reaction in " + host.Buffer
-			}
+		watchQuery(t, ev, channelName, query, reaction != "")
+	}
+}
 
-			go host.Watch()
-			hostGroup.Add(hosts[i][1], host)
-		}
+// watchQuery starts watching the provided query (ip, hostname, url, etc),
+// if it's not already being watched. If notifyExists is true, the user will
+// be notified if the query is already being watched.
+func watchQuery(t Transport, ev *Event, channelName, query string, notifyExists bool) {
+	msg, reaction := ev.Message, ev.Reaction
 
+	probe, ip, err := newProbe(query, nil)
+	if err != nil {
 		return
 	}
 
-	// We should loop through each IP we find and track it.
-	for _, ip := range ips {
-		netIP := net.ParseIP(ip)
-
-		// Make sure it's a valid ip, and also make sure that
-		// we're not already tracking the ip.
-		if netIP == nil {
-			continue
-		}
-		if ok, buffer := hostGroup.Exists(netIP.String()); ok {
+	// Make sure that we're not already tracking the query.
+	if ok, buffer := hostGroup.Exists(probe.Target()); ok {
+		if notifyExists {
+			// Convert the reaction into a message, essentially, allowing
+			// us to respond directly to them.
 			if reaction != "" {
-				// Convert the reaction into a message, essentially, allowing
-				// us to respond directly to them.
-				if radded, ok := ev.(*slack.ReactionAddedEvent); ok {
-					msg = slackRefToMessage(radded.Item.Channel, radded.User, radded.Item.Timestamp)
-				} else if rdel, ok := ev.(*slack.ReactionRemovedEvent); ok {
-					msg = slackRefToMessage(rdel.Item.Channel, rdel.User, rdel.Item.Timestamp)
+				msg = &Message{
+					Transport: msg.Transport, Channel: msg.Channel, User: ev.ReactionUser,
+					Timestamp: msg.Timestamp, ThreadTimestamp: msg.ThreadTimestamp,
 				}
-				slackReply(msg, true, fmt.Sprintf("<@%s>: %s already monitored, ignoring (%s)", msg.User, netIP.String(), buffer))
 			}
-			continue
+			replyTo(msg, true, fmt.Sprintf("%s: %s already monitored, ignoring (%s)", t.Mention(msg.User), probe.Target(), buffer))
 		}
+		return
+	}
 
-		host := &Host{
-			closer:         make(chan struct{}, 1),
-			Origin:         msg,
-			IP:             netIP,
-			Added:          time.Now(),
-			Buffer:         channelName,
-			OriginReaction: reaction,
-			Highlight:      []string{},
-		}
+	host := &Host{
+		closer:         make(chan struct{}, 1),
+		Origin:         msg,
+		IP:             ip,
+		Query:          query,
+		Probe:          probe,
+		Added:          time.Now(),
+		Buffer:         channelName,
+		OriginReaction: reaction,
+		Highlight:      []string{},
+		Cadence:        conf.Probe,
+	}
 
-		if reaction != "" {
-			host.Buffer = "via reaction in " + host.Buffer
-		}
+	if reaction != "" {
+		host.Buffer = "via reaction in " + host.Buffer
+	}
 
-		go host.Watch()
-		hostGroup.Add(netIP.String(), host)
+	// IPs are tracked by their normalized form.
+	if addr, _ := splitHostPort(query); net.ParseIP(addr) != nil {
+		query = probe.Target()
+	}
+
+	if err = hostGroup.Add(query, host); err != nil {
+		return
+	}
+
+	go host.Watch()
+}
+
+func catchPanic(t Transport, msg *Message) {
+	if r := recover(); r != nil {
+
+		threaded := true
+		if ch, err := t.ChannelID(t.IncomingChannel()); err == nil {
+			if ch != msg.Channel {
+				msg.Channel = ch
+				threaded = false
+			}
+		}
+		replyTo(msg, threaded, fmt.Sprintf("An exception occurred (`panic: %s`), poke lstanley. restarting bot.", r))
 	}
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+// historyBucket is the bucket that probe results and state transitions are
+// stored in.
+const historyBucket = "history"
+
+// Events which are stored in the history of a check.
+const (
+	eventStart   = "start"
+	eventResult  = "result"
+	eventOnline  = "online"
+	eventOffline = "offline"
+	eventStop    = "stop"
+	// eventUnreachable is an offline transition while the parent of the
+	// check was also offline.
+	eventUnreachable = "unreachable"
+
+	eventDegraded  = "degraded"
+	eventRecovered = "recovered"
+	eventFlapping  = "flapping"
+	eventStable    = "stable"
+
+	eventMaintStart = "maintenance"
+	eventMaintEnd   = "maintenance_end"
+)
+
+// HistoryEntry is a single probe result or state transition of a check.
+type HistoryEntry struct {
+	ID int `storm:"id,increment"`
+	// Check is the ID of the check (see Hosts.Add).
+	Check  string    `storm:"index"`
+	Target string    `storm:"index"`
+	Addr   string    `storm:"index"`
+	Time   time.Time `storm:"index"`
+	Event  string
+
+	Online  bool
+	Latency time.Duration
+	Error   string
+	Detail  string
+
+	// Origin of the check.
+	Transport string
+	User      string
+	Channel   string
+	Thread    string
+	Buffer    string
+}
+
+// record stores an event (and optionally, the probe result that caused it)
+// in the history of the host. detail is used if the result has no detail of
+// its own.
+func (h *Host) record(event, detail string, result *ProbeResult) {
+	if event == eventResult && conf.History.TransitionsOnly {
+		return
+	}
+
+	entry := &HistoryEntry{
+		Check:     h.ID,
+		Target:    h.Probe.Target(),
+		Time:      time.Now(),
+		Event:     event,
+		Online:    h.Online,
+		Detail:    detail,
+		Transport: h.Origin.Transport,
+		User:      h.Origin.User,
+		Channel:   h.Origin.Channel,
+		Thread:    h.Origin.ThreadTimestamp,
+		Buffer:    h.Buffer,
+	}
+
+	if entry.Thread == "" {
+		entry.Thread = h.Origin.Timestamp
+	}
+
+	if h.IP != nil {
+		entry.Addr = h.IP.String()
+	}
+
+	if result != nil {
+		entry.Online = result.Online()
+		entry.Latency = result.Latency
+		if result.Detail != "" {
+			entry.Detail = result.Detail
+		}
+		if result.Err != nil {
+			entry.Error = result.Err.Error()
+		}
+	}
+
+	db := getDB()
+
+	if err := db.From(historyBucket).Save(entry); err != nil {
+		logger.Printf("unable to save history for %s: %s", h.ID, err)
+	}
+}
+
+// GetHistory returns the history of the check matching query (the check ID,
+// probe target, or address), since the provided time. Short hostnames also
+// match their fully qualified check (e.g. "web01" matches
+// "web01.example.com"). If events are provided, only those events are
+// returned.
+func GetHistory(query string, since time.Time, events ...string) (entries []*HistoryEntry) {
+	node := getDB().From(historyBucket)
+	id := strings.ToLower(query)
+
+	// Each lookup uses an index, rather than scanning the whole bucket.
+	var byCheck, byTarget, byAddr []*HistoryEntry
+	for _, err := range []error{
+		node.Prefix("Check", id, &byCheck),
+		node.Find("Target", query, &byTarget),
+		node.Find("Addr", query, &byAddr),
+	} {
+		if err != nil && err != storm.ErrNotFound {
+			logger.Printf("unable to query history for %s: %s", query, err)
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, event := range events {
+		wanted[event] = true
+	}
+
+	seen := make(map[int]bool)
+	for _, entry := range append(append(byCheck, byTarget...), byAddr...) {
+		if seen[entry.ID] || entry.Time.Before(since) || (len(events) > 0 && !wanted[entry.Event]) {
+			continue
+		}
+
+		// Check prefixes must end on a boundary, so "web01" doesn't match
+		// "web011".
+		matched := entry.Target == query || entry.Addr == query
+		if !matched && strings.HasPrefix(entry.Check, id) {
+			rest := entry.Check[len(id):]
+			matched = rest == "" || rest[0] == '.' || rest[0] == ':'
+		}
+
+		if !matched {
+			continue
+		}
+
+		seen[entry.ID] = true
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	return entries
+}
+
+// pruneHistory periodically removes history older than the configured
+// retention.
+func pruneHistory() {
+	for {
+		cutoff := time.Now().Add(-time.Duration(conf.History.RetentionDays) * 24 * time.Hour)
+
+		err := getDB().From(historyBucket).Select(q.Lt("Time", cutoff)).Delete(new(HistoryEntry))
+
+		if err != nil && err != storm.ErrNotFound {
+			logger.Printf("unable to prune history: %s", err)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+// Outage is a period of time where a check was offline.
+type Outage struct {
+	Check  string
+	Target string
+	Start  time.Time
+	// End is zero if the outage is still ongoing.
+	End time.Time
+	// Resolved is false if the check was stopped while still offline.
+	Resolved  bool
+	Transport string
+	User      string
+	Buffer    string
+}
+
+// Duration returns the length of the outage, up until now if it's still
+// ongoing.
+func (o *Outage) Duration() time.Duration {
+	if o.End.IsZero() {
+		return time.Since(o.Start)
+	}
+
+	return o.End.Sub(o.Start)
+}
+
+// GetOutages returns all outages for checks matching query (see GetHistory),
+// since the provided time.
+func GetOutages(query string, since time.Time) (outages []*Outage) {
+	entries := GetHistory(query, since, eventStart, eventOnline, eventOffline, eventUnreachable, eventStop)
+
+	// Checks may have been started more than once, so track each separately.
+	active := make(map[string]*Outage)
+	for _, entry := range entries {
+		key := entry.Check + "/" + entry.Thread
+		outage := active[key]
+
+		switch {
+		case (entry.Event == eventStart || entry.Event == eventOffline || entry.Event == eventUnreachable) && !entry.Online && outage == nil:
+			outage = &Outage{
+				Check:     entry.Check,
+				Target:    entry.Target,
+				Start:     entry.Time,
+				Transport: entry.Transport,
+				User:      entry.User,
+				Buffer:    entry.Buffer,
+			}
+			active[key] = outage
+			outages = append(outages, outage)
+		case entry.Event == eventOnline && outage != nil:
+			outage.End = entry.Time
+			outage.Resolved = true
+			delete(active, key)
+		case entry.Event == eventStop && outage != nil:
+			outage.End = entry.Time
+			delete(active, key)
+		}
+	}
+
+	return outages
+}
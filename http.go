@@ -32,6 +32,8 @@ func httpServer() {
 	</head>
 	<body style="padding: 20px;">
 		<a href="$PREFIX/checks">checks</a><br>
+		<a href="$PREFIX/history/">history</a> (/history/&lt;check, target or ip&gt;?since=7d)<br>
+		<a href="$PREFIX/report/">report</a> (/report/&lt;check, target or ip&gt;?since=7d, /report/incident/&lt;id&gt;)<br>
 		<a href="$PREFIX/usersettings">user settings</a><br>
 		<a href="$PREFIX/slack/conninfo">connection info/slack user list</a><br>
 		<a href="$PREFIX/debug">debug</a><br>
@@ -52,6 +54,44 @@ func httpServer() {
 		})
 	})
 
+	r.Get(flags.HTTPPrefix+"/history/*", func(w http.ResponseWriter, r *http.Request) {
+		since := 24 * time.Hour
+		if in := r.URL.Query().Get("since"); in != "" {
+			var err error
+			if since, err = parseDuration(in); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		JSON(w, r, GetHistory(chi.URLParam(r, "*"), time.Now().Add(-since)))
+	})
+
+	r.Get(flags.HTTPPrefix+"/report/*", func(w http.ResponseWriter, r *http.Request) {
+		since := 7 * 24 * time.Hour
+		if in := r.URL.Query().Get("since"); in != "" {
+			var err error
+			if since, err = parseDuration(in); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		query := chi.URLParam(r, "*")
+		if strings.HasPrefix(query, "incident/") {
+			query = "#" + strings.TrimPrefix(query, "incident/")
+		}
+
+		out, err := report(query, time.Now().Add(-since))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		fmt.Fprint(w, out)
+	})
+
 	r.Get(flags.HTTPPrefix+"/usersettings", func(w http.ResponseWriter, r *http.Request) { JSON(w, r, GetAllUserSettings()) })
 	r.Get(flags.HTTPPrefix+"/slack/conninfo", func(w http.ResponseWriter, r *http.Request) { JSON(w, r, lastConnectInfo) })
 	r.Mount(flags.HTTPPrefix+"/debug", middleware.Profiler())
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// pingv6 sends a single ICMPv6 echo request to the provided ip, and waits
+// for a matching echo reply. Like ICMPv4, this requires the ability to open
+// raw sockets.
+func pingv6(ctx context.Context, ip net.IP, timeout time.Duration) error {
+	conn, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	// Unblock any pending reads if the context is cancelled.
+	go func() {
+		<-ctx.Done()
+		conn.SetDeadline(time.Now())
+	}()
+
+	id := os.Getpid() & 0xffff
+	seq := rand.Intn(0xffff)
+
+	// The kernel calculates the checksum for ICMPv6 raw sockets, so it is
+	// left zeroed.
+	req := []byte{
+		icmpv6EchoRequest, 0, 0, 0,
+		byte(id >> 8), byte(id), byte(seq >> 8), byte(seq),
+		'p', 'o', 'n', 'g', 'e', 'r',
+	}
+
+	if _, err = conn.WriteTo(req, &net.IPAddr{IP: ip}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				return errors.New("timed out waiting for echo reply")
+			}
+
+			return err
+		}
+
+		if n < 8 || buf[0] != icmpv6EchoReply {
+			continue
+		}
+
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(ip) {
+			continue
+		}
+
+		if int(buf[4])<<8|int(buf[5]) != id || int(buf[6])<<8|int(buf[7]) != seq {
+			continue
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/asdine/storm"
+)
+
+// ircMaxLine is the maximum length of the text of a single message, leaving
+// room for the prefix added by the server.
+const ircMaxLine = 400
+
+// ircMaxLines is the maximum amount of lines sent for a single reply, to
+// avoid flooding.
+const ircMaxLines = 25
+
+// ircTransport is an IRC transport. As IRC has no threads or reactions,
+// replies are prefixed with the nick of the user who started the check, and
+// a short id of the message the check was started from.
+type ircTransport struct {
+	// smu is held while sending a message, so the lines of concurrent
+	// messages aren't interleaved.
+	smu sync.Mutex
+
+	mu     sync.Mutex
+	conn   net.Conn
+	nick   string
+	lastID int64
+
+	// restarted is true once the restart message has been sent.
+	restarted bool
+}
+
+func newIRCTransport() *ircTransport {
+	return &ircTransport{nick: conf.IRC.Nick}
+}
+
+func (c *ircTransport) Name() string { return "irc" }
+
+func (c *ircTransport) IncomingChannel() string {
+	if conf.IRC.IncomingChannel != "" {
+		return conf.IRC.IncomingChannel
+	}
+
+	if len(conf.IRC.Channels) > 0 {
+		return conf.IRC.Channels[0]
+	}
+
+	return ""
+}
+
+// ircMessage is a single parsed line from the server.
+type ircMessage struct {
+	Nick    string
+	Command string
+	Params  []string
+}
+
+// parseIRC parses a raw line from the server.
+func parseIRC(line string) *ircMessage {
+	msg := &ircMessage{}
+
+	if strings.HasPrefix(line, "@") {
+		// Message tags aren't used.
+		if i := strings.Index(line, " "); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			return msg
+		}
+
+		msg.Nick = line[1:i]
+		if j := strings.Index(msg.Nick, "!"); j >= 0 {
+			msg.Nick = msg.Nick[:j]
+		}
+		line = line[i+1:]
+	}
+
+	var trailing string
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing = line[i+2:]
+		line = line[:i]
+		msg.Params = append(strings.Fields(line), trailing)
+	} else {
+		msg.Params = strings.Fields(line)
+	}
+
+	if len(msg.Params) > 0 {
+		msg.Command = strings.ToUpper(msg.Params[0])
+		msg.Params = msg.Params[1:]
+	}
+
+	return msg
+}
+
+func (c *ircTransport) Run(handler EventHandler) error {
+	if conf.IRC.Nick == "" || c.IncomingChannel() == "" {
+		return errors.New("nick and at least one channel must be configured")
+	}
+
+	c.seedID()
+
+	var failures int
+	for {
+		start := time.Now()
+		err := c.connect(handler)
+
+		// Only back off if the connection didn't last.
+		if time.Since(start) > 5*time.Minute {
+			failures = 0
+		}
+		failures++
+
+		delay := time.Duration(failures*failures) * 5 * time.Second
+		if delay > 5*time.Minute {
+			delay = 5 * time.Minute
+		}
+
+		logger.Printf("irc connection to %s lost: %s (reconnecting in %s)", conf.IRC.Server, err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// connect connects to the server, and handles messages until the connection
+// fails.
+func (c *ircTransport) connect(handler EventHandler) error {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if conf.IRC.TLS {
+		host, _ := splitHostPort(conf.IRC.Server)
+		conn, err = tls.DialWithDialer(dialer, "tcp", conf.IRC.Server, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: conf.IRC.TLSSkipVerify,
+		})
+	} else {
+		conn, err = dialer.Dial("tcp", conf.IRC.Server)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	c.mu.Lock()
+	c.conn = conn
+	c.nick = conf.IRC.Nick
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+	}()
+
+	if conf.IRC.SASLUser != "" {
+		c.write("CAP REQ :sasl")
+	}
+	if conf.IRC.Password != "" {
+		c.write("PASS " + conf.IRC.Password)
+	}
+
+	name := conf.IRC.Name
+	if name == "" {
+		name = "ponger"
+	}
+
+	c.write("NICK " + conf.IRC.Nick)
+	c.write(fmt.Sprintf("USER %s 0 * :%s", conf.IRC.Nick, name))
+
+	scanner := bufio.NewScanner(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		if !scanner.Scan() {
+			if err = scanner.Err(); err == nil {
+				err = errors.New("connection closed")
+			}
+
+			return err
+		}
+
+		msg := parseIRC(scanner.Text())
+		if flags.Debug {
+			logger.Printf("irc: %s", scanner.Text())
+		}
+
+		if err = c.handle(msg, handler); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a single message from the server.
+func (c *ircTransport) handle(msg *ircMessage, handler EventHandler) error {
+	param := func(i int) string {
+		if i < len(msg.Params) {
+			return msg.Params[i]
+		}
+
+		return ""
+	}
+
+	switch msg.Command {
+	case "PING":
+		c.write("PONG :" + param(0))
+	case "CAP":
+		switch strings.ToUpper(param(1)) {
+		case "ACK":
+			c.write("AUTHENTICATE PLAIN")
+		case "NAK":
+			logger.Printf("irc server doesn't support sasl")
+			c.write("CAP END")
+		}
+	case "AUTHENTICATE":
+		if param(0) == "+" {
+			auth := conf.IRC.SASLUser + "\x00" + conf.IRC.SASLUser + "\x00" + conf.IRC.SASLPassword
+			c.write("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(auth)))
+		}
+	case "903":
+		// SASL authentication successful.
+		c.write("CAP END")
+	case "904", "905":
+		c.write("CAP END")
+		return errors.New("sasl authentication failed")
+	case "433":
+		// Nick in use.
+		c.mu.Lock()
+		c.nick += "_"
+		nick := c.nick
+		c.mu.Unlock()
+
+		c.write("NICK " + nick)
+	case "001":
+		c.mu.Lock()
+		c.nick = param(0)
+		c.mu.Unlock()
+
+		logger.Printf("connected to %s as %s", conf.IRC.Server, param(0))
+
+		if conf.IRC.NickServPassword != "" {
+			c.write("PRIVMSG NickServ :IDENTIFY " + conf.IRC.NickServPassword)
+		}
+
+		for _, channel := range conf.IRC.Channels {
+			c.write("JOIN " + channel)
+		}
+
+		if conf.IRC.IncomingChannel != "" && !ircContains(conf.IRC.Channels, conf.IRC.IncomingChannel) {
+			c.write("JOIN " + conf.IRC.IncomingChannel)
+		}
+	case "366":
+		// End of names, i.e. a channel was joined.
+		if strings.EqualFold(param(1), c.IncomingChannel()) && !c.restarted {
+			c.restarted = true
+			c.send(param(1), "", restartMessage())
+		}
+	case "PRIVMSG":
+		c.mu.Lock()
+		self := strings.EqualFold(msg.Nick, c.nick)
+		c.mu.Unlock()
+
+		text := param(1)
+		if self || msg.Nick == "" || strings.HasPrefix(text, "\x01") {
+			// Our own messages, and CTCP.
+			break
+		}
+
+		channel := param(0)
+		if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+			// Private message, so reply to the sender.
+			channel = msg.Nick
+		}
+
+		// Commands may take a while, so don't hold up the connection (e.g.
+		// replying to pings) while they run.
+		go handler(c, &Event{Message: &Message{
+			Transport: c.Name(),
+			Channel:   channel,
+			User:      msg.Nick,
+			Text:      text,
+			Timestamp: c.newID(),
+		}})
+	}
+
+	return nil
+}
+
+func ircContains(channels []string, channel string) bool {
+	for _, ch := range channels {
+		if strings.EqualFold(ch, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newID returns a short id for a message, used in place of threads.
+func (c *ircTransport) newID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastID++
+	return strconv.FormatInt(c.lastID, 36)
+}
+
+// seedID continues ids after the highest id used by stored checks and
+// incidents, so new ids don't collide with the ones resumed after a restart.
+func (c *ircTransport) seedID() {
+	var ids []string
+	hostGroup.Lock()
+	for _, host := range hostGroup.inv {
+		if host.Origin != nil && host.Origin.Transport == c.Name() {
+			ids = append(ids, host.Origin.Timestamp, host.Origin.ThreadTimestamp)
+		}
+	}
+	hostGroup.Unlock()
+
+	var incidents []*Incident
+	err := getDB().From(incidentBucket).Find("Transport", c.Name(), &incidents)
+	if err != nil && err != storm.ErrNotFound {
+		logger.Printf("unable to load stored incidents: %s", err)
+	}
+	for _, inc := range incidents {
+		ids = append(ids, inc.Message)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if n, err := strconv.ParseInt(id, 36, 64); err == nil && n > c.lastID {
+			c.lastID = n
+		}
+	}
+}
+
+// write sends a raw line to the server.
+func (c *ircTransport) write(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return errors.New("not connected")
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := fmt.Fprintf(c.conn, "%s\r\n", strings.NewReplacer("\r", "", "\n", " ").Replace(line))
+	return err
+}
+
+var (
+	reIRCCodeFence = regexp.MustCompile("(?m)^```$\n?|```")
+	reIRCBold      = regexp.MustCompile(`(^|\s)\*([^*\n]+)\*`)
+)
+
+// ircFormat converts Slack formatted text for IRC, removing code formatting
+// and converting bold text.
+func ircFormat(text string) string {
+	text = replaceShortcodes(text)
+	text = reIRCCodeFence.ReplaceAllString(text, "")
+	text = strings.Replace(text, "`", "", -1)
+	text = reIRCBold.ReplaceAllString(text, "$1\x02$2\x02")
+
+	return text
+}
+
+// ircLines splits formatted text into the lines sent to the server, with
+// each line prefixed with prefix. Long lines are split without splitting
+// multi-byte characters.
+func ircLines(prefix, text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		for len(line) > ircMaxLine {
+			i := ircMaxLine
+			for i > 0 && !utf8.RuneStart(line[i]) {
+				i--
+			}
+
+			lines = append(lines, prefix+line[:i])
+			line = line[i:]
+		}
+		lines = append(lines, prefix+line)
+	}
+
+	if len(lines) > ircMaxLines {
+		lines = append(lines[:ircMaxLines], fmt.Sprintf("%s(%d more lines truncated)", prefix, len(lines)-ircMaxLines))
+	}
+
+	return lines
+}
+
+// send sends text to the target, split into multiple messages if needed,
+// with each line prefixed with prefix.
+func (c *ircTransport) send(target, prefix, text string) error {
+	lines := ircLines(prefix, ircFormat(text))
+
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	for i, line := range lines {
+		// Avoid being kicked for flooding.
+		if i > 3 {
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		if err := c.write("PRIVMSG " + target + " :" + line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *ircTransport) Reply(msg *Message, thread bool, text string) error {
+	prefix := msg.User + ": "
+	if thread {
+		id := msg.ThreadTimestamp
+		if id == "" {
+			id = msg.Timestamp
+		}
+
+		prefix += "[" + id + "] "
+	}
+
+	return c.send(msg.Channel, prefix, text)
+}
+
+func (c *ircTransport) Post(channel, text string) (string, error) {
+	id := c.newID()
+	return id, c.send(channel, "["+id+"] ", text)
+}
+
+// Update sends the updated text as a new message, as messages can't be
+// edited.
+func (c *ircTransport) Update(channel, id, text string) error {
+	return c.send(channel, "["+id+" updated] ", text)
+}
+
+func (c *ircTransport) ChannelID(name string) (string, error) { return name, nil }
+
+// ChannelName returns the channel, or an empty string for private messages.
+func (c *ircTransport) ChannelName(id string) string {
+	if strings.HasPrefix(id, "#") || strings.HasPrefix(id, "&") {
+		return id
+	}
+
+	return ""
+}
+
+func (c *ircTransport) UserName(id string) string           { return id }
+func (c *ircTransport) Mention(id string) string            { return id }
+func (c *ircTransport) Permalink(channel, id string) string { return "" }
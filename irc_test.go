@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestIRCLines(t *testing.T) {
+	long := strings.Repeat("a", ircMaxLine)
+
+	var many []string
+	for i := 0; i < ircMaxLines+5; i++ {
+		many = append(many, fmt.Sprintf("line %d", i))
+	}
+
+	tests := []struct {
+		prefix string
+		in     string
+		want   []string
+	}{
+		{"", "", nil},
+		{"bob: ", "web01 is down", []string{"bob: web01 is down"}},
+		{"bob: [1] ", "a\n\n  \nb", []string{"bob: [1] a", "bob: [1] b"}},
+		{"", long + "b", []string{long, "b"}},
+		// Multi-byte characters aren't split across lines.
+		{"", long[1:] + "éb", []string{long[1:], "éb"}},
+		{"", long[2:] + "🏓b", []string{long[2:], "🏓b"}},
+		{
+			"[1] ", strings.Join(many, "\n"),
+			append(ircPrefix("[1] ", many[:ircMaxLines]), "[1] (5 more lines truncated)"),
+		},
+	}
+
+	for _, tt := range tests {
+		got := ircLines(tt.prefix, tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ircLines(%q, %q) = %q, want %q", tt.prefix, tt.in, got, tt.want)
+		}
+
+		for _, line := range got {
+			if !utf8.ValidString(line) {
+				t.Errorf("ircLines(%q, %q) returned invalid line %q", tt.prefix, tt.in, line)
+			}
+		}
+	}
+}
+
+func ircPrefix(prefix string, lines []string) (out []string) {
+	for _, line := range lines {
+		out = append(out, prefix+line)
+	}
+
+	return out
+}
+
+func TestIRCNewID(t *testing.T) {
+	tests := []struct {
+		last int64
+		want []string
+	}{
+		{0, []string{"1", "2", "3"}},
+		{34, []string{"z", "10", "11"}},
+		// Continues after ids of stored checks (see seedID).
+		{36*36*36*36*36 - 1, []string{"100000", "100001"}},
+	}
+
+	for _, tt := range tests {
+		c := &ircTransport{lastID: tt.last}
+
+		var got []string
+		for range tt.want {
+			got = append(got, c.newID())
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("newID() after %d = %q, want %q", tt.last, got, tt.want)
+		}
+	}
+}
+
+func TestIRCNewIDUnique(t *testing.T) {
+	c := &ircTransport{}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				id := c.newID()
+
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("newID() returned duplicate id %q", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// lifetimeOptions are the options which control how long a check is watched
+// for (see Host.setLifetime).
+var lifetimeOptions = []string{"for", "until", "removal"}
+
+// expiryWarning is how long before a check expires that the user is warned,
+// so they have a chance to extend it.
+const expiryWarning = 10 * time.Minute
+
+// parseUntil parses a time of day (e.g. "18:00" or "6pm"), returning the next
+// occurrence of it after now.
+func parseUntil(in string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"15:04", "3:04pm", "3pm"} {
+		t, err := time.ParseInLocation(layout, strings.ToLower(in), now.Location())
+		if err != nil {
+			continue
+		}
+
+		until := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !until.After(now) {
+			until = until.AddDate(0, 0, 1)
+		}
+
+		return until, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %q (expected e.g. 18:00)", in)
+}
+
+// setLifetime sets how long the host is watched for from the provided
+// options: "for" (a duration, e.g. 2h), "until" (a time of day, e.g. 18:00),
+// and "removal" (how long the host must stay online before it's no longer
+// watched, or "never"). If a lifetime is supplied without "removal", the
+// host is watched for the full lifetime.
+func (h *Host) setLifetime(opts Options) error {
+	now := time.Now()
+
+	if value, ok := opts["for"]; ok {
+		dur, err := parseDuration(value)
+		if err != nil || dur <= 0 {
+			return fmt.Errorf("invalid duration for `for`: %q", value)
+		}
+
+		h.Expires = now.Add(dur)
+	}
+
+	if value, ok := opts["until"]; ok {
+		if !h.Expires.IsZero() {
+			return errors.New("only one of `for` and `until` can be supplied")
+		}
+
+		until, err := parseUntil(value, now)
+		if err != nil {
+			return err
+		}
+
+		h.Expires = until
+	}
+
+	if !h.Expires.IsZero() {
+		h.RemovalTimeout = -1
+	}
+
+	if value, ok := opts["removal"]; ok {
+		if value == "never" || value == "0" {
+			h.RemovalTimeout = -1
+			return nil
+		}
+
+		dur, err := parseDuration(value)
+		if err != nil || dur <= 0 {
+			return fmt.Errorf("invalid duration for `removal`: %q", value)
+		}
+
+		h.RemovalTimeout = dur
+	}
+
+	return nil
+}
+
+// expires returns when the host will no longer be watched, regardless of its
+// state.
+func (h *Host) expires() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.expiresLocked()
+}
+
+// expiresLocked is like expires, but must be called with h.mu held.
+func (h *Host) expiresLocked() time.Time {
+	if !h.Expires.IsZero() {
+		return h.Expires
+	}
+
+	return h.Added.Add(time.Duration(conf.ForcedTimeout) * time.Second)
+}
+
+// expiresIn returns how long until the host expires, for display purposes.
+func (h *Host) expiresIn() string {
+	if h.Pinned {
+		return "never"
+	}
+
+	return time.Until(h.expires()).Truncate(time.Second).String()
+}
+
+// removalTimeout returns how long the host must be online before it's no
+// longer watched. If negative, the host is watched until it expires.
+func (h *Host) removalTimeout() time.Duration {
+	if h.RemovalTimeout != 0 {
+		return h.RemovalTimeout
+	}
+
+	return time.Duration(conf.RemovalTimeout) * time.Second
+}
+
+// checkExpiry warns the user shortly before the host expires, and returns
+// false once it has.
+func (h *Host) checkExpiry() bool {
+	if h.Pinned {
+		return true
+	}
+
+	left := time.Until(h.expires())
+
+	if left <= 0 {
+		reason := fmt.Sprintf("stopped monitoring %s: check expired (watched for `%s`)", h.Probe.Target(), time.Since(h.Added).Truncate(time.Second))
+		h.Send(reason)
+		hostGroup.LRemove(h.ID, reason)
+		return false
+	}
+
+	// Don't bother warning for checks which were only meant to be short
+	// lived.
+	h.mu.Lock()
+	warn := left <= expiryWarning && !h.ExpiryWarned && h.expiresLocked().Sub(h.Added) > 2*expiryWarning
+	if warn {
+		h.ExpiryWarned = true
+	}
+	h.mu.Unlock()
+
+	if warn {
+		h.Sendf(
+			"%s will no longer be monitored in `%s`, use `!extend %s <duration>` to keep watching it",
+			h.Probe.Target(), left.Truncate(time.Second), h.Probe.Target(),
+		)
+	}
+
+	return true
+}
+
+// extend pushes out when the host expires by the provided duration. The
+// host is saved by its own goroutine, on the next round of probes.
+func (h *Host) extend(dur time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	expires := h.expiresLocked()
+	if expires.Before(time.Now()) {
+		expires = time.Now()
+	}
+
+	h.Expires = expires.Add(dur)
+	h.ExpiryWarned = false
+}
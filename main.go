@@ -1,23 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	gflags "github.com/jessevdk/go-flags"
-	"github.com/nlopes/slack"
-	"github.com/paulstuart/ping"
 )
 
 type Flags struct {
 	ConfigFile string `short:"c" long:"config" description:"configuration file location" default:"config.toml"`
 	Debug      bool   `short:"d" long:"debug" description:"enables slack api debugging"`
-	UserDB     string `long:"user-db" description:"path to user settings database file" default:"user_settings.db"`
+	UserDB     string `long:"user-db" description:"path to database file (user settings, active checks)" default:"user_settings.db"`
 	HTTP       string `long:"http" description:"address/port to bind to" default:":8080"`
 	HTTPPrefix string `long:"http-prefix" description:"prefix uri for the http server (e.g. if behind a proxy)"`
 	Ping       string `long:"ping" short:"p" description:"test the ping functionality builtin to ponger"`
+	Console    bool   `long:"console" description:"read messages from stdin and write replies to stdout, instead of connecting to slack"`
 }
 
 var flags Flags
@@ -31,11 +34,88 @@ type Config struct {
 	ReactionTrigger string `toml:"reaction_trigger"`
 	HTTPUser        string `toml:"http_user"`
 	HTTPPasswd      string `toml:"http_password"`
+
+	TCP struct {
+		ReadBanner bool `toml:"read_banner"`
+	} `toml:"tcp"`
+
+	URL struct {
+		Method          string `toml:"method"`
+		ExpectStatus    string `toml:"expect_status"`
+		BodyMatch       string `toml:"body_match"`
+		BodyRegex       string `toml:"body_regex"`
+		FollowRedirects bool   `toml:"follow_redirects"`
+		TimeoutSecs     int    `toml:"timeout_secs"`
+	} `toml:"url"`
+
+	TLS struct {
+		ExpiryThresholdDays int `toml:"expiry_threshold_days"`
+	} `toml:"tls"`
+
+	History struct {
+		RetentionDays   int  `toml:"retention_days"`
+		TransitionsOnly bool `toml:"transitions_only"`
+	} `toml:"history"`
+
+	Probe ProbeConfig `toml:"probe"`
+
+	Checks      []CheckConfig `toml:"check"`
+	Maintenance []MaintConfig `toml:"maintenance"`
+
+	Matrix MatrixConfig `toml:"matrix"`
+
+	IRC struct {
+		// Server is the host:port of the server. The transport is disabled
+		// if empty.
+		Server        string `toml:"server"`
+		TLS           bool   `toml:"tls"`
+		TLSSkipVerify bool   `toml:"tls_skip_verify"`
+		Nick          string `toml:"nick"`
+		Name          string `toml:"name"`
+		// Password is the server password, if any.
+		Password         string   `toml:"password"`
+		SASLUser         string   `toml:"sasl_user"`
+		SASLPassword     string   `toml:"sasl_password"`
+		NickServPassword string   `toml:"nickserv_password"`
+		Channels         []string `toml:"channels"`
+		// IncomingChannel defaults to the first channel.
+		IncomingChannel string `toml:"incoming_channel"`
+	} `toml:"irc"`
+
+	Discord struct {
+		// Token is the bot token. The transport is disabled if empty.
+		Token string `toml:"token"`
+		// IncomingChannel is the name (e.g. "#ops") or id of the channel.
+		IncomingChannel string `toml:"incoming_channel"`
+		ReactionTrigger string `toml:"reaction_trigger"`
+		// Guild limits channel name lookups to a single guild (server) id.
+		Guild string `toml:"guild"`
+	} `toml:"discord"`
+
+	Correlation struct {
+		Window   Duration `toml:"window"`
+		MinHosts int      `toml:"min_hosts"`
+	} `toml:"correlation"`
+
+	// Parents maps checks (globs, see Host.matches) to the check they depend
+	// on.
+	Parents map[string]string `toml:"parents"`
+
+	Flap struct {
+		Window         Duration `toml:"window"`
+		Threshold      int      `toml:"threshold"`
+		DigestInterval Duration `toml:"digest_interval"`
+		Stable         Duration `toml:"stable"`
+	} `toml:"flap"`
+
+	Thresholds struct {
+		LossPercent float64 `toml:"loss_percent"`
+		LatencyMS   int     `toml:"latency_ms"`
+	} `toml:"thresholds"`
 }
 
 var conf Config
 var logger = log.New(os.Stdout, "", log.Lshortfile|log.LstdFlags)
-var toSlack = make(chan string, 20)
 
 func main() {
 	parser := gflags.NewParser(&flags, gflags.HelpFlag)
@@ -46,7 +126,11 @@ func main() {
 	}
 
 	if flags.Ping != "" {
-		err = ping.Pinger(flags.Ping, 2)
+		var ip net.IP
+		if ip, err = resolve(flags.Ping); err == nil {
+			err = (&ICMPProbe{IP: ip}).Run(context.Background()).Err
+		}
+
 		if err == nil {
 			logger.Println("PING OK")
 			os.Exit(0)
@@ -69,11 +153,85 @@ func main() {
 		conf.ForcedTimeout = 240
 	}
 
-	slack.SetLogger(logger)
+	if conf.URL.Method == "" {
+		conf.URL.Method = http.MethodGet
+	}
+
+	if conf.URL.ExpectStatus == "" {
+		conf.URL.ExpectStatus = "200-399"
+	}
+
+	if conf.URL.TimeoutSecs < 1 {
+		conf.URL.TimeoutSecs = 10
+	}
+
+	if conf.TLS.ExpiryThresholdDays < 1 {
+		conf.TLS.ExpiryThresholdDays = 14
+	}
+
+	conf.Probe.setDefaults()
+
+	if conf.Flap.Threshold == 0 {
+		conf.Flap.Threshold = 5
+	}
+
+	if conf.Flap.Window.Duration <= 0 {
+		conf.Flap.Window.Duration = 10 * time.Minute
+	}
+
+	if conf.Flap.DigestInterval.Duration <= 0 {
+		conf.Flap.DigestInterval.Duration = 10 * time.Minute
+	}
+
+	if conf.Flap.Stable.Duration <= 0 {
+		conf.Flap.Stable.Duration = 10 * time.Minute
+	}
+
+	if conf.Correlation.Window.Duration <= 0 {
+		conf.Correlation.Window.Duration = 2 * time.Minute
+	}
+
+	if conf.Correlation.MinHosts == 0 {
+		conf.Correlation.MinHosts = 3
+	}
+
+	if conf.History.RetentionDays < 1 {
+		conf.History.RetentionDays = 30
+	}
+
+	if flags.Console {
+		// Keep stdout for the conversation.
+		logger.SetOutput(os.Stderr)
+		registerTransport(newConsoleTransport())
+	} else {
+		if conf.Token != "" {
+			registerTransport(newSlackTransport())
+		}
+
+		if conf.Matrix.Homeserver != "" {
+			registerTransport(newMatrixTransport(conf.Matrix))
+		}
+
+		if conf.IRC.Server != "" {
+			registerTransport(newIRCTransport())
+		}
+
+		if conf.Discord.Token != "" {
+			registerTransport(newDiscordTransport())
+		}
+	}
+
+	if err = loadMaintenance(); err != nil {
+		logger.Fatalf("unable to load maintenance windows: %s", err)
+	}
+
+	resumedChecks = resumeChecks()
+	logger.Printf("started %d pinned checks", startPinnedChecks())
+	go pruneHistory()
 
 	go httpServer()
 
-	if err := newSlackRTM(toSlack); err != nil {
+	if err := runTransports(); err != nil {
 		logger.Fatalln(err)
 	}
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain stores anything saved by the tests in a temporary database.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "ponger")
+	if err != nil {
+		panic(err)
+	}
+
+	flags.UserDB = filepath.Join(dir, "test.db")
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	glob "github.com/ryanuber/go-glob"
+)
+
+// maintBucket is the bucket that ad-hoc maintenance windows are stored in.
+const maintBucket = "maintenance"
+
+// MaintConfig is a recurring maintenance window, defined in the
+// configuration.
+type MaintConfig struct {
+	// Schedule is a cron expression of when the window starts (see
+	// cronSchedule).
+	Schedule string `toml:"schedule"`
+	// Duration is how long the window lasts.
+	Duration Duration `toml:"duration"`
+	// Match is a glob matched against the query, ip and target of checks
+	// (e.g. "*.example.com").
+	Match  string `toml:"match"`
+	Reason string `toml:"reason"`
+
+	schedule *cronSchedule
+}
+
+// MaintWindow is an ad-hoc maintenance window, created via !maint.
+type MaintWindow struct {
+	ID        int `storm:"id,increment"`
+	Match     string
+	Start     time.Time
+	End       time.Time
+	Reason    string
+	Transport string
+	User      string
+}
+
+// MaintState is the state of a host during a maintenance window.
+type MaintState struct {
+	Reason string
+	Start  time.Time
+	End    time.Time
+	// Transitions is the amount of state changes during the window.
+	Transitions int
+	// Downtime is the time spent offline during the window.
+	Downtime time.Duration
+}
+
+var maintWindows = struct {
+	sync.Mutex
+	windows []*MaintWindow
+}{}
+
+// loadMaintenance parses the configured maintenance windows, and loads any
+// ad-hoc windows which haven't ended yet.
+func loadMaintenance() error {
+	for i := range conf.Maintenance {
+		m := &conf.Maintenance[i]
+
+		var err error
+		if m.schedule, err = parseCron(m.Schedule); err != nil {
+			return err
+		}
+
+		if m.Duration.Duration <= 0 {
+			return fmt.Errorf("maintenance window %q has no duration", m.Schedule)
+		}
+	}
+
+	var windows []*MaintWindow
+
+	if err := getDB().From(maintBucket).All(&windows); err != nil {
+		return err
+	}
+
+	maintWindows.Lock()
+	defer maintWindows.Unlock()
+
+	maintWindows.windows = append(maintWindows.windows, windows...)
+	pruneMaintenance()
+
+	return nil
+}
+
+// pruneMaintenance removes ad-hoc maintenance windows which have ended. Must
+// be called with maintWindows locked.
+func pruneMaintenance() {
+	var windows []*MaintWindow
+	for _, w := range maintWindows.windows {
+		if time.Now().Before(w.End) {
+			windows = append(windows, w)
+			continue
+		}
+
+		if err := getDB().From(maintBucket).DeleteStruct(w); err != nil && err != storm.ErrNotFound {
+			logger.Printf("unable to delete maintenance window %d: %s", w.ID, err)
+		}
+	}
+
+	maintWindows.windows = windows
+}
+
+// addMaintenance starts an ad-hoc maintenance window for checks matching the
+// provided query.
+func addMaintenance(query string, dur time.Duration, reason string, msg *Message) (*MaintWindow, error) {
+	w := &MaintWindow{
+		Match:     query,
+		Start:     time.Now(),
+		End:       time.Now().Add(dur),
+		Reason:    reason,
+		Transport: msg.Transport,
+		User:      msg.User,
+	}
+
+	db := getDB()
+
+	if err := db.From(maintBucket).Save(w); err != nil {
+		return nil, err
+	}
+
+	maintWindows.Lock()
+	pruneMaintenance()
+	maintWindows.windows = append(maintWindows.windows, w)
+	maintWindows.Unlock()
+
+	return w, nil
+}
+
+// endMaintenance ends all ad-hoc maintenance windows with the provided query,
+// returning the amount of windows which were ended.
+func endMaintenance(query string) (ended int) {
+	maintWindows.Lock()
+	defer maintWindows.Unlock()
+
+	db := getDB()
+
+	var windows []*MaintWindow
+	for _, w := range maintWindows.windows {
+		if strings.EqualFold(w.Match, query) {
+			if err := db.From(maintBucket).DeleteStruct(w); err != nil && err != storm.ErrNotFound {
+				logger.Printf("unable to delete maintenance window %d: %s", w.ID, err)
+			}
+
+			ended++
+			continue
+		}
+
+		windows = append(windows, w)
+	}
+
+	maintWindows.windows = windows
+	return ended
+}
+
+// activeMaintenance returns the ad-hoc maintenance windows which haven't
+// ended yet.
+func activeMaintenance() []*MaintWindow {
+	maintWindows.Lock()
+	defer maintWindows.Unlock()
+
+	pruneMaintenance()
+	return append([]*MaintWindow(nil), maintWindows.windows...)
+}
+
+// matches returns true if the query (a glob) matches the query, ip (if one
+// was resolved) or target of the host.
+func (h *Host) matches(query string) bool {
+	return glob.Glob(strings.ToLower(query), strings.ToLower(h.ID)) ||
+		(h.IP != nil && glob.Glob(query, h.IP.String())) || glob.Glob(query, h.Probe.Target())
+}
+
+// maintenance returns the maintenance window the host is currently in, if
+// any.
+func (h *Host) maintenance() (state *MaintState) {
+	now := time.Now()
+
+	for _, w := range activeMaintenance() {
+		if h.matches(w.Match) && (state == nil || w.End.After(state.End)) {
+			state = &MaintState{Reason: w.Reason, Start: w.Start, End: w.End}
+		}
+	}
+
+	for _, m := range conf.Maintenance {
+		if m.schedule == nil || !h.matches(m.Match) {
+			continue
+		}
+
+		start, ok := m.schedule.Last(now, m.Duration.Duration)
+		if !ok || !now.Before(start.Add(m.Duration.Duration)) {
+			continue
+		}
+
+		if state == nil || start.Add(m.Duration.Duration).After(state.End) {
+			state = &MaintState{Reason: m.Reason, Start: start, End: start.Add(m.Duration.Duration)}
+		}
+	}
+
+	return state
+}
+
+// checkMaintenance updates the maintenance state of the host, posting a
+// summary once a maintenance window has ended.
+func (h *Host) checkMaintenance() {
+	state := h.maintenance()
+
+	if state != nil {
+		if h.Maint == nil {
+			h.Maint = state
+			h.record(eventMaintStart, state.Reason, nil)
+			return
+		}
+
+		// The window may have been extended (or replaced by an overlapping
+		// one).
+		h.Maint.End, h.Maint.Reason = state.End, state.Reason
+		if !h.Online {
+			h.Maint.Downtime += time.Since(h.LastOffline)
+		}
+		return
+	}
+
+	if h.Maint == nil {
+		return
+	}
+
+	state, h.Maint = h.Maint, nil
+
+	status := "online :white_check_mark:"
+	if !h.Online {
+		status = "offline :warn1:"
+	}
+
+	reason := ""
+	if state.Reason != "" {
+		reason = fmt.Sprintf(" (%s)", state.Reason)
+	}
+
+	h.Sendf(
+		"maintenance window for %s%s ended after `%s`: `%d` state changes, `%s` downtime, now %s",
+		h.Probe.Target(), reason, time.Since(state.Start).Truncate(time.Second), state.Transitions,
+		state.Downtime.Truncate(time.Second), status,
+	)
+	h.record(eventMaintEnd, fmt.Sprintf("%d state changes, %s downtime", state.Transitions, state.Downtime.Truncate(time.Second)), nil)
+}
+
+// suppress tracks a state transition of the host, and returns true if the
+// transition notification should be suppressed, either as the host is in a
+// maintenance window, or is flapping (see Host.flap).
+func (h *Host) suppress() bool {
+	if h.Maint != nil {
+		h.Maint.Transitions++
+		return true
+	}
+
+	return h.flap()
+}
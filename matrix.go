@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// matrixMaxReactions is the maximum amount of reactions tracked (see
+// matrixTransport.reactions), after which the oldest are forgotten.
+const matrixMaxReactions = 1000
+
+// MatrixConfig is the configuration of the Matrix transport.
+type MatrixConfig struct {
+	// Homeserver is the base url of the homeserver (e.g.
+	// https://matrix.example.com). The transport is disabled if empty.
+	Homeserver  string `toml:"homeserver"`
+	AccessToken string `toml:"access_token"`
+	// UserID is the user id of the bot, looked up from the access token if
+	// empty.
+	UserID          string `toml:"user_id"`
+	IncomingRoom    string `toml:"incoming_room"`
+	ReactionTrigger string `toml:"reaction_trigger"`
+	AutoJoin        bool   `toml:"auto_join"`
+}
+
+// matrixTransport is a Matrix transport, using the client-server api.
+// Threads are mapped to "m.thread" relations, and reactions to "m.annotation"
+// relations (with removals being redactions of the reaction).
+type matrixTransport struct {
+	cfg    MatrixConfig
+	client *http.Client
+	txnID  int64
+
+	mu sync.Mutex
+	// rooms caches room aliases to ids, and names caches room ids to
+	// aliases.
+	rooms map[string]string
+	names map[string]string
+	// reactions tracks reactions to messages, so removals (redactions) can
+	// be mapped back to them. reactionOrder is the order they were added
+	// in, so the oldest can be forgotten.
+	reactions     map[string]*Event
+	reactionOrder []string
+}
+
+func newMatrixTransport(cfg MatrixConfig) *matrixTransport {
+	cfg.ReactionTrigger = reactionEmoji(cfg.ReactionTrigger)
+
+	return &matrixTransport{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: 90 * time.Second},
+		rooms:     make(map[string]string),
+		names:     make(map[string]string),
+		reactions: make(map[string]*Event),
+	}
+}
+
+// matrixError is an error returned by the homeserver.
+type matrixError struct {
+	Status  int
+	ErrCode string `json:"errcode"`
+	Err     string `json:"error"`
+}
+
+func (e *matrixError) Error() string {
+	return fmt.Sprintf("%s: %s (status %d)", e.ErrCode, e.Err, e.Status)
+}
+
+// matrixRelation is the "m.relates_to" field of an event.
+type matrixRelation struct {
+	RelType   string `json:"rel_type,omitempty"`
+	EventID   string `json:"event_id,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Fallback  bool   `json:"is_falling_back,omitempty"`
+	InReplyTo *struct {
+		EventID string `json:"event_id"`
+	} `json:"m.in_reply_to,omitempty"`
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	EventID string `json:"event_id"`
+	Sender  string `json:"sender"`
+	RoomID  string `json:"room_id"`
+	// Redacts is the event being redacted, for redaction events (it's in the
+	// content for newer room versions).
+	Redacts string `json:"redacts"`
+	Content struct {
+		MsgType   string          `json:"msgtype"`
+		Body      string          `json:"body"`
+		Redacts   string          `json:"redacts"`
+		RelatesTo *matrixRelation `json:"m.relates_to"`
+	} `json:"content"`
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []*matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]json.RawMessage `json:"invite"`
+	} `json:"rooms"`
+}
+
+func (m *matrixTransport) Name() string            { return "matrix" }
+func (m *matrixTransport) IncomingChannel() string { return m.cfg.IncomingRoom }
+
+// do sends a request to the homeserver, decoding the response into out (if
+// not nil).
+func (m *matrixTransport) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	uri := strings.TrimSuffix(m.cfg.Homeserver, "/") + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, uri, &buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+m.cfg.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		merr := &matrixError{Status: resp.StatusCode}
+		if err = json.Unmarshal(data, merr); err != nil || merr.ErrCode == "" {
+			merr.ErrCode, merr.Err = "M_UNKNOWN", http.StatusText(resp.StatusCode)
+		}
+
+		return merr
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func (m *matrixTransport) Run(handler EventHandler) error {
+	if m.IncomingChannel() == "" {
+		return errors.New("no incoming room configured")
+	}
+
+	if m.cfg.UserID == "" {
+		var whoami struct {
+			UserID string `json:"user_id"`
+		}
+
+		if err := m.do(context.Background(), http.MethodGet, "/account/whoami", nil, nil, &whoami); err != nil {
+			return err
+		}
+		m.cfg.UserID = whoami.UserID
+	}
+
+	incoming, err := m.ChannelID(m.IncomingChannel())
+	if err != nil {
+		return fmt.Errorf("unable to resolve incoming room %q: %s", m.IncomingChannel(), err)
+	}
+
+	logger.Printf("connected to %s as %s", m.cfg.Homeserver, m.cfg.UserID)
+
+	var since string
+	var failures int
+	for {
+		query := url.Values{"timeout": {"30000"}}
+		if since != "" {
+			query.Set("since", since)
+		} else {
+			// Skip history on the initial sync, only new events are
+			// handled.
+			query.Set("filter", `{"room":{"timeline":{"limit":1}}}`)
+		}
+
+		var resp matrixSync
+		if err = m.do(context.Background(), http.MethodGet, "/sync", query, nil, &resp); err != nil {
+			if merr, ok := err.(*matrixError); ok && merr.Status == http.StatusUnauthorized {
+				return err
+			}
+
+			failures++
+			logger.Printf("matrix sync failed (attempt %d): %s", failures, err)
+			if failures > 10 {
+				return err
+			}
+
+			time.Sleep(time.Duration(failures) * 5 * time.Second)
+			continue
+		}
+		failures = 0
+
+		if since == "" {
+			if _, err = m.Post(incoming, restartMessage()); err != nil {
+				logger.Printf("unable to send restart message: %s", err)
+			}
+		} else {
+			m.handleSync(&resp, handler)
+		}
+
+		since = resp.NextBatch
+	}
+}
+
+// handleSync passes the events from a sync response to the handler.
+func (m *matrixTransport) handleSync(resp *matrixSync, handler EventHandler) {
+	if m.cfg.AutoJoin {
+		for room := range resp.Rooms.Invite {
+			if err := m.do(context.Background(), http.MethodPost, "/join/"+url.PathEscape(room), nil, struct{}{}, nil); err != nil {
+				logger.Printf("unable to join %s: %s", room, err)
+			}
+		}
+	}
+
+	for room, joined := range resp.Rooms.Join {
+		for _, ev := range joined.Timeline.Events {
+			if ev.Sender == m.cfg.UserID {
+				continue
+			}
+			ev.RoomID = room
+
+			// Commands may take a while, so don't hold up syncing while they
+			// run.
+			if e := m.event(ev); e != nil {
+				go handler(m, e)
+			}
+		}
+	}
+}
+
+// event converts a matrix event into an Event, returning nil if it should be
+// ignored.
+func (m *matrixTransport) event(ev *matrixEvent) *Event {
+	switch ev.Type {
+	case "m.room.message":
+		if rel := ev.Content.RelatesTo; rel != nil && rel.RelType == "m.replace" {
+			// Edits.
+			return nil
+		}
+
+		return &Event{Message: m.message(ev)}
+	case "m.reaction":
+		rel := ev.Content.RelatesTo
+		if rel == nil || rel.RelType != "m.annotation" || !m.isTrigger(rel.Key) {
+			return nil
+		}
+
+		var target matrixEvent
+		err := m.do(context.Background(), http.MethodGet, "/rooms/"+url.PathEscape(ev.RoomID)+"/event/"+url.PathEscape(rel.EventID), nil, nil, &target)
+		if err != nil {
+			logger.Printf("unable to fetch %s in %s: %s", rel.EventID, ev.RoomID, err)
+			return nil
+		}
+		target.RoomID = ev.RoomID
+
+		if target.Sender == m.cfg.UserID {
+			return nil
+		}
+
+		e := &Event{Message: m.message(&target), Reaction: rel.Key, ReactionUser: ev.Sender}
+
+		m.mu.Lock()
+		m.reactions[ev.EventID] = e
+		m.reactionOrder = append(m.reactionOrder, ev.EventID)
+		if len(m.reactionOrder) > matrixMaxReactions {
+			delete(m.reactions, m.reactionOrder[0])
+			m.reactionOrder = m.reactionOrder[1:]
+		}
+		m.mu.Unlock()
+
+		return e
+	case "m.room.redaction":
+		redacts := ev.Redacts
+		if redacts == "" {
+			redacts = ev.Content.Redacts
+		}
+
+		m.mu.Lock()
+		e, ok := m.reactions[redacts]
+		delete(m.reactions, redacts)
+		m.mu.Unlock()
+
+		if !ok || e.ReactionUser != ev.Sender {
+			return nil
+		}
+
+		return &Event{Message: e.Message, Reaction: e.Reaction, ReactionUser: e.ReactionUser, ReactionRemoved: true}
+	}
+
+	return nil
+}
+
+// reReplyFallback matches the quoted fallback of replies, which is removed
+// so the contents of the original message aren't picked up again.
+var reReplyFallback = regexp.MustCompile(`(?s)^(?:> [^\n]*\n)+\n`)
+
+// message converts a matrix message event into a Message.
+func (m *matrixTransport) message(ev *matrixEvent) *Message {
+	msg := &Message{
+		Transport: m.Name(),
+		Channel:   ev.RoomID,
+		User:      ev.Sender,
+		Text:      ev.Content.Body,
+		Timestamp: ev.EventID,
+	}
+
+	if rel := ev.Content.RelatesTo; rel != nil {
+		if rel.RelType == "m.thread" {
+			msg.ThreadTimestamp = rel.EventID
+		}
+
+		if rel.InReplyTo != nil {
+			msg.Text = reReplyFallback.ReplaceAllString(msg.Text, "")
+		}
+	}
+
+	return msg
+}
+
+// isTrigger returns true if the reaction key matches the configured
+// reaction trigger (either the emoji itself, or its shortcode).
+func (m *matrixTransport) isTrigger(key string) bool {
+	trigger := m.cfg.ReactionTrigger
+
+	return key == trigger || key == ":"+trigger+":"
+}
+
+// send sends a message event to a room, returning the id of the event.
+func (m *matrixTransport) send(room string, content map[string]interface{}) (string, error) {
+	txnID := fmt.Sprintf("ponger-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&m.txnID, 1))
+
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+
+	path := "/rooms/" + url.PathEscape(room) + "/send/m.room.message/" + url.PathEscape(txnID)
+	if err := m.do(context.Background(), http.MethodPut, path, nil, content, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.EventID, nil
+}
+
+// content returns the content of a notice with the provided text, which is
+// converted from Slack formatting to HTML.
+func (m *matrixTransport) content(text string) map[string]interface{} {
+	text = replaceShortcodes(text)
+
+	return map[string]interface{}{
+		"msgtype":        "m.notice",
+		"body":           text,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": matrixHTML(text),
+	}
+}
+
+func (m *matrixTransport) Reply(msg *Message, thread bool, text string) error {
+	content := m.content(text)
+
+	if thread {
+		root := msg.ThreadTimestamp
+		if root == "" {
+			root = msg.Timestamp
+		}
+
+		content["m.relates_to"] = map[string]interface{}{
+			"rel_type":        "m.thread",
+			"event_id":        root,
+			"is_falling_back": true,
+			"m.in_reply_to":   map[string]string{"event_id": msg.Timestamp},
+		}
+	}
+
+	_, err := m.send(msg.Channel, content)
+	return err
+}
+
+func (m *matrixTransport) Post(channel, text string) (string, error) {
+	return m.send(channel, m.content(text))
+}
+
+func (m *matrixTransport) Update(channel, id, text string) error {
+	content := m.content("* " + text)
+	content["m.new_content"] = m.content(text)
+	content["m.relates_to"] = map[string]string{"rel_type": "m.replace", "event_id": id}
+
+	_, err := m.send(channel, content)
+	return err
+}
+
+// ChannelID resolves a room alias (e.g. "#ops:example.com") to its id. If the
+// alias has no server name, the server of the bot is assumed. Room ids are
+// returned as-is.
+func (m *matrixTransport) ChannelID(name string) (string, error) {
+	if strings.HasPrefix(name, "!") {
+		return name, nil
+	}
+
+	if !strings.HasPrefix(name, "#") {
+		name = "#" + name
+	}
+
+	if !strings.Contains(name, ":") {
+		if i := strings.Index(m.cfg.UserID, ":"); i >= 0 {
+			name += m.cfg.UserID[i:]
+		}
+	}
+
+	m.mu.Lock()
+	id, ok := m.rooms[name]
+	m.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+
+	if err := m.do(context.Background(), http.MethodGet, "/directory/room/"+url.PathEscape(name), nil, nil, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.RoomID == "" {
+		return "", errors.New("room not found")
+	}
+
+	m.mu.Lock()
+	m.rooms[name] = resp.RoomID
+	m.names[resp.RoomID] = name
+	m.mu.Unlock()
+
+	return resp.RoomID, nil
+}
+
+// ChannelName returns the alias of the provided room. The incoming room is
+// always returned as configured, so it can be compared against.
+func (m *matrixTransport) ChannelName(id string) string {
+	if incoming, err := m.ChannelID(m.IncomingChannel()); err == nil && incoming == id {
+		return m.IncomingChannel()
+	}
+
+	m.mu.Lock()
+	name, ok := m.names[id]
+	m.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	var resp struct {
+		Alias string `json:"alias"`
+	}
+
+	path := "/rooms/" + url.PathEscape(id) + "/state/m.room.canonical_alias"
+	if err := m.do(context.Background(), http.MethodGet, path, nil, nil, &resp); err != nil || resp.Alias == "" {
+		resp.Alias = id
+	}
+
+	m.mu.Lock()
+	m.names[id] = resp.Alias
+	m.mu.Unlock()
+
+	return resp.Alias
+}
+
+func (m *matrixTransport) UserName(id string) string { return id }
+func (m *matrixTransport) Mention(id string) string  { return id }
+
+func (m *matrixTransport) Permalink(channel, id string) string {
+	if channel == "" || id == "" {
+		return ""
+	}
+
+	return "https://matrix.to/#/" + url.PathEscape(channel) + "/" + url.PathEscape(id)
+}
+
+var (
+	reCodeBlock  = regexp.MustCompile("(?s)```\n?(.*?)```")
+	reInlineCode = regexp.MustCompile("`([^`\n]+)`")
+	reBold       = regexp.MustCompile(`(^|\s)\*([^*\n]+)\*`)
+	reItalic     = regexp.MustCompile(`(^|\s)_([^_\n]+)_`)
+	reQuote      = regexp.MustCompile(`(?m)^&gt; ?(.*)$`)
+	reMXID       = regexp.MustCompile(`@[a-zA-Z0-9._=/+-]+:[a-zA-Z0-9.-]+(?::\d+)?`)
+)
+
+// matrixHTML converts Slack formatted text (code, bold, italics and quotes)
+// into HTML, with mentions of users converted to pills.
+func matrixHTML(text string) string {
+	text = html.EscapeString(text)
+
+	// Code blocks are replaced with placeholders, so their contents aren't
+	// formatted.
+	var blocks []string
+	text = reCodeBlock.ReplaceAllStringFunc(text, func(block string) string {
+		blocks = append(blocks, "<pre><code>"+reCodeBlock.FindStringSubmatch(block)[1]+"</code></pre>")
+		return fmt.Sprintf("\x00%d\x00", len(blocks)-1)
+	})
+
+	text = reInlineCode.ReplaceAllString(text, "<code>$1</code>")
+	text = reBold.ReplaceAllString(text, "$1<strong>$2</strong>")
+	text = reItalic.ReplaceAllString(text, "$1<em>$2</em>")
+	text = reQuote.ReplaceAllString(text, "<blockquote>$1</blockquote>")
+	text = reMXID.ReplaceAllStringFunc(text, func(id string) string {
+		return fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>`, id, id)
+	})
+	text = strings.Replace(strings.TrimRight(text, "\n"), "\n", "<br>", -1)
+
+	for i, block := range blocks {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), block, 1)
+	}
+
+	return text
+}
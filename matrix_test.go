@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHomeserver is a minimal stand-in for a homeserver, which returns a
+// single batch of events after the initial sync, and records sent messages.
+type fakeHomeserver struct {
+	events []map[string]interface{}
+
+	mu   sync.Mutex
+	sent []map[string]interface{}
+}
+
+func (f *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/_matrix/client/v3")
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errcode":"M_UNKNOWN_TOKEN","error":"unknown token"}`)
+		return
+	}
+
+	switch {
+	case path == "/directory/room/%23ops:example.com":
+		fmt.Fprint(w, `{"room_id":"!ops:example.com"}`)
+	case path == "/sync":
+		var timeline []map[string]interface{}
+		switch r.URL.Query().Get("since") {
+		case "":
+		case "s1":
+			timeline = f.events
+		default:
+			// Nothing else happens, avoid spinning.
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		resp := map[string]interface{}{"next_batch": "s2"}
+		if r.URL.Query().Get("since") == "" {
+			resp["next_batch"] = "s1"
+		}
+		resp["rooms"] = map[string]interface{}{
+			"join": map[string]interface{}{
+				"!ops:example.com": map[string]interface{}{"timeline": map[string]interface{}{"events": timeline}},
+			},
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	case path == "/rooms/%21ops:example.com/event/$target":
+		fmt.Fprint(w, `{"type":"m.room.message","event_id":"$target","sender":"@alice:example.com","content":{"msgtype":"m.text","body":"10.0.0.1 is down"}}`)
+	case strings.HasPrefix(path, "/rooms/%21ops:example.com/send/m.room.message/") && r.Method == http.MethodPut:
+		var content map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&content)
+
+		f.mu.Lock()
+		f.sent = append(f.sent, content)
+		id := len(f.sent)
+		f.mu.Unlock()
+
+		fmt.Fprintf(w, `{"event_id":"$sent%d"}`, id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errcode":"M_NOT_FOUND","error":"not found"}`)
+	}
+}
+
+// waitSent waits for n messages to have been sent, returning them.
+func (f *fakeHomeserver) waitSent(t *testing.T, n int) []map[string]interface{} {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		f.mu.Lock()
+		sent := f.sent
+		f.mu.Unlock()
+
+		if len(sent) >= n {
+			return sent
+		}
+	}
+
+	t.Fatalf("timed out waiting for %d sent messages", n)
+	return nil
+}
+
+func TestMatrixTransport(t *testing.T) {
+	fake := &fakeHomeserver{events: []map[string]interface{}{
+		{
+			"type": "m.room.message", "event_id": "$reply", "sender": "@bob:example.com",
+			"content": map[string]interface{}{
+				"msgtype": "m.text", "body": "> <@alice:example.com> hi\n\n!check web01",
+				"m.relates_to": map[string]interface{}{
+					"rel_type": "m.thread", "event_id": "$root",
+					"m.in_reply_to": map[string]string{"event_id": "$root"},
+				},
+			},
+		},
+		{
+			// Our own messages are ignored.
+			"type": "m.room.message", "event_id": "$own", "sender": "@ponger:example.com",
+			"content": map[string]interface{}{"msgtype": "m.notice", "body": "ignored"},
+		},
+		{
+			"type": "m.reaction", "event_id": "$reaction", "sender": "@bob:example.com",
+			"content": map[string]interface{}{
+				"m.relates_to": map[string]string{"rel_type": "m.annotation", "event_id": "$target", "key": "🏓"},
+			},
+		},
+		{
+			// Reactions which aren't the trigger are ignored.
+			"type": "m.reaction", "event_id": "$other", "sender": "@bob:example.com",
+			"content": map[string]interface{}{
+				"m.relates_to": map[string]string{"rel_type": "m.annotation", "event_id": "$target", "key": "👍"},
+			},
+		},
+		{"type": "m.room.redaction", "event_id": "$redaction", "sender": "@bob:example.com", "redacts": "$reaction"},
+	}}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	m := newMatrixTransport(MatrixConfig{
+		Homeserver:      server.URL,
+		AccessToken:     "token",
+		UserID:          "@ponger:example.com",
+		IncomingRoom:    "#ops:example.com",
+		ReactionTrigger: "🏓",
+	})
+
+	events := make(chan *Event, 10)
+	go m.Run(func(_ Transport, ev *Event) { events <- ev })
+
+	// Events are handled concurrently, so order them as they were sent.
+	got := make([]*Event, 3)
+	for i := 0; i < len(got); i++ {
+		select {
+		case ev := <-events:
+			switch {
+			case ev.Reaction == "":
+				got[0] = ev
+			case !ev.ReactionRemoved:
+				got[1] = ev
+			default:
+				got[2] = ev
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %d", i)
+		}
+	}
+
+	for i, ev := range got {
+		if ev == nil {
+			t.Fatalf("missing event %d: %v", i, got)
+		}
+	}
+
+	msg := got[0].Message
+	if msg.Channel != "!ops:example.com" || msg.User != "@bob:example.com" || msg.Timestamp != "$reply" ||
+		msg.ThreadTimestamp != "$root" || msg.Text != "!check web01" || got[0].Reaction != "" {
+		t.Errorf("unexpected message event: %+v", msg)
+	}
+
+	if got[1].Reaction != "🏓" || got[1].ReactionUser != "@bob:example.com" || got[1].ReactionRemoved ||
+		got[1].Message.Timestamp != "$target" || got[1].Message.Text != "10.0.0.1 is down" {
+		t.Errorf("unexpected reaction event: %+v (%+v)", got[1], got[1].Message)
+	}
+
+	if got[2].Reaction != "🏓" || !got[2].ReactionRemoved || got[2].Message.Timestamp != "$target" {
+		t.Errorf("unexpected redaction event: %+v", got[2])
+	}
+
+	// The restart message is sent after the initial sync.
+	if sent := fake.waitSent(t, 1); sent[0]["body"] != restartMessage() {
+		t.Errorf("unexpected restart message: %v", sent[0])
+	}
+
+	if err := m.Reply(msg, true, "*web01* now offline :warn1:"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := fake.waitSent(t, 2)[1]
+	if reply["body"] != "*web01* now offline ⚠️" || reply["formatted_body"] != "<strong>web01</strong> now offline ⚠️" {
+		t.Errorf("unexpected reply: %v", reply)
+	}
+
+	rel, _ := reply["m.relates_to"].(map[string]interface{})
+	if rel["rel_type"] != "m.thread" || rel["event_id"] != "$root" || rel["is_falling_back"] != true {
+		t.Errorf("unexpected reply relation: %v", rel)
+	}
+
+	if inReplyTo, _ := rel["m.in_reply_to"].(map[string]interface{}); inReplyTo["event_id"] != "$reply" {
+		t.Errorf("unexpected reply relation: %v", rel)
+	}
+}
+
+func TestMatrixReactionsBounded(t *testing.T) {
+	m := newMatrixTransport(MatrixConfig{ReactionTrigger: "🏓"})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type":"m.room.message","event_id":"$target","sender":"@alice:example.com","content":{"body":"x"}}`)
+	}))
+	defer server.Close()
+	m.cfg.Homeserver = server.URL
+
+	for i := 0; i < matrixMaxReactions+10; i++ {
+		ev := &matrixEvent{Type: "m.reaction", EventID: fmt.Sprintf("$r%d", i), Sender: "@bob:example.com", RoomID: "!ops:example.com"}
+		ev.Content.RelatesTo = &matrixRelation{RelType: "m.annotation", EventID: "$target", Key: "🏓"}
+
+		if m.event(ev) == nil {
+			t.Fatalf("reaction %d was ignored", i)
+		}
+	}
+
+	if len(m.reactions) != matrixMaxReactions {
+		t.Errorf("tracking %d reactions, want %d", len(m.reactions), matrixMaxReactions)
+	}
+
+	if _, ok := m.reactions["$r0"]; ok {
+		t.Errorf("oldest reaction wasn't forgotten")
+	}
+}
+
+func TestMatrixIsTrigger(t *testing.T) {
+	defer func(trigger string) { conf.ReactionTrigger = trigger }(conf.ReactionTrigger)
+	conf.ReactionTrigger = "ponger"
+
+	tests := []struct {
+		trigger string
+		key     string
+		want    bool
+	}{
+		// Custom Slack emoji don't exist in Matrix.
+		{"", "🏓", true},
+		{"", "ponger", false},
+		{"", ":ponger:", false},
+		{"👀", "👀", true},
+		{"👀", "🏓", false},
+		{"ping_pong", "🏓", true},
+		{":rotating_light:", "🚨", true},
+	}
+
+	for _, tt := range tests {
+		m := newMatrixTransport(MatrixConfig{ReactionTrigger: tt.trigger})
+
+		if got := m.isTrigger(tt.key); got != tt.want {
+			t.Errorf("isTrigger(%q) with trigger %q = %t, want %t", tt.key, tt.trigger, got, tt.want)
+		}
+	}
+}
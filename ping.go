@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -9,10 +10,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/nlopes/slack"
-	"github.com/paulstuart/ping"
-	glob "github.com/ryanuber/go-glob"
 )
 
 var hostGroup = Hosts{inv: make(map[string]*Host)}
@@ -28,20 +25,26 @@ func (h *Hosts) Dump() (out string) {
 
 	var keys []string
 	var maxLen int
+	maxIPLen := 15
 	for key := range h.inv {
 		if len(key) > maxLen {
 			maxLen = len(key)
 		}
 
+		if ipLen := len(h.inv[key].Addr()); ipLen > maxIPLen {
+			maxIPLen = ipLen
+		}
+
 		keys = append(keys, key)
 	}
 	sort.Strings(keys)
 
 	for _, key := range keys {
 		out += fmt.Sprintf(
-			"q: %-"+strconv.Itoa(maxLen)+"s | ip: %-15s | watching: %8s | online: %-5t | src: %s\n",
-			key, h.inv[key].IP, time.Since(h.inv[key].Added).Truncate(time.Second), h.inv[key].Online,
-			h.inv[key].Buffer,
+			"q: %-"+strconv.Itoa(maxLen)+"s | ip: %-"+strconv.Itoa(maxIPLen)+"s | probe: %-4s | watching: %8s | expires: %8s | online: %-5t | %s | src: %s\n",
+			key, h.inv[key].Addr(), h.inv[key].Probe.Name(), time.Since(h.inv[key].Added).Truncate(time.Second),
+			h.inv[key].expiresIn(), h.inv[key].isOnline(),
+			h.inv[key].statsString(), h.inv[key].Buffer,
 		)
 	}
 
@@ -53,17 +56,15 @@ func (h *Hosts) GlobRemove(query, user string) {
 	defer h.Unlock()
 
 	for key := range h.inv {
-		if query != "" {
-			if glob.Glob(strings.ToLower(query), strings.ToLower(key)) {
-				h.Remove(key, "checks cancelled")
-				continue
-			}
+		if h.inv[key].Pinned {
+			continue
+		}
 
-			if glob.Glob(query, h.inv[key].IP.String()) {
+		if query != "" {
+			if h.inv[key].matches(query) {
 				h.Remove(key, "checks cancelled")
-				continue
 			}
-			return
+			continue
 		}
 
 		if user != "" {
@@ -77,6 +78,26 @@ func (h *Hosts) GlobRemove(query, user string) {
 	}
 }
 
+// Extend pushes out when checks matching the provided query expire, returning
+// the checks which were extended.
+func (h *Hosts) Extend(query string, dur time.Duration) (extended []*Host) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, host := range h.inv {
+		if host.Pinned {
+			continue
+		}
+
+		if host.matches(query) {
+			host.extend(dur)
+			extended = append(extended, host)
+		}
+	}
+
+	return extended
+}
+
 func (h *Hosts) Exists(id string) (ok bool, buffer string) {
 	h.Lock()
 	defer h.Unlock()
@@ -88,7 +109,7 @@ func (h *Hosts) Exists(id string) (ok bool, buffer string) {
 			return true, h.inv[key].Buffer
 		}
 
-		if h.inv[key].IP.String() == id {
+		if strings.ToLower(h.inv[key].Probe.Target()) == id {
 			return true, h.inv[key].Buffer
 		}
 
@@ -116,9 +137,10 @@ func (h *Hosts) Add(id string, host *Host) error {
 		return errors.New("host already tracked")
 	}
 
-	logger.Printf("added: %s", host.IP)
+	logger.Printf("added: %s", host.Probe.Target())
 
 	h.inv[id] = host
+	host.save()
 	return nil
 }
 
@@ -130,7 +152,8 @@ func (h *Hosts) Remove(id, reason string) bool {
 			h.inv[id].Send(reason)
 		}
 
-		close(h.inv[id].closer)
+		h.inv[id].record(eventStop, reason, nil)
+		h.inv[id].stop(id)
 		delete(h.inv, id)
 		return true
 	}
@@ -142,7 +165,8 @@ func (h *Hosts) Remove(id, reason string) bool {
 				h.inv[key].Send(reason)
 			}
 
-			close(h.inv[key].closer)
+			h.inv[key].record(eventStop, reason, nil)
+			h.inv[key].stop(key)
 			delete(h.inv, key)
 			removed = true
 		}
@@ -168,45 +192,153 @@ func (h *Hosts) EditHighlight(ts, user string, add bool) {
 			continue
 		}
 
-		if add {
-			if user == h.inv[key].Origin.User {
-				continue
-			}
-
-			h.inv[key].Highlight = append(h.inv[key].Highlight, user)
-		} else {
-			hl := []string{}
-			for _, uid := range h.inv[key].Highlight {
-				if uid != user {
-					hl = append(hl, uid)
-				}
-			}
-
-			h.inv[key].Highlight = hl
+		if add && user == h.inv[key].Origin.User {
+			continue
 		}
 
-		if len(h.inv[key].Highlight) == 0 && h.inv[key].OriginReaction != "" {
-			h.inv[key].Send("no longer monitoring: " + h.inv[key].IP.String())
+		// The host is saved by its own goroutine, on the next round of
+		// probes.
+		if h.inv[key].editHighlight(user, add) == 0 && h.inv[key].OriginReaction != "" {
+			h.inv[key].Send("no longer monitoring: " + h.inv[key].Probe.Target())
 			_ = h.Remove(h.inv[key].ID, "")
 		}
 	}
 }
 
+// editHighlight adds or removes a user to be highlighted when sending
+// notifications, returning the amount of users which are highlighted.
+func (h *Host) editHighlight(user string, add bool) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// A new slice is always used, as Host.Send uses the previous one without
+	// holding the lock.
+	hl := []string{}
+	for _, uid := range h.Highlight {
+		if uid != user {
+			hl = append(hl, uid)
+		}
+	}
+
+	if add {
+		hl = append(hl, user)
+	}
+
+	h.Highlight = hl
+	return len(hl)
+}
+
 type Host struct {
-	ID                string
-	closer            chan struct{}
-	Origin            *slack.Message
-	OriginReaction    string
-	Buffer            string
-	IP                net.IP
+	ID             string `storm:"id"`
+	closer         chan struct{}
+	Origin         *Message
+	OriginReaction string
+	Buffer         string
+	IP             net.IP
+	// Query is what the probe was created from (see newProbe), and is used
+	// to re-create the probe when resuming the check after a restart.
+	Query string
+	// Options are the options the check was created with (see parseArgs).
+	Options           Options
+	Probe             Probe `json:"-"`
 	Added             time.Time
 	HasSentFirstReply bool
-	Highlight         []string
-
+	// Expires is when the host is no longer watched, if a custom lifetime
+	// was supplied (see Host.expires).
+	Expires      time.Time
+	ExpiryWarned bool
+	// RemovalTimeout is how long the host must be online before it's no
+	// longer watched, if overridden (see Host.removalTimeout).
+	RemovalTimeout time.Duration
+	// Pinned is true if the check is defined in the configuration (see
+	// CheckConfig). Pinned checks never expire, can't be cleared, and aren't
+	// stored.
+	Pinned    bool
+	Highlight []string
+
+	// mu guards the fields which are used by other goroutines: Online and
+	// Stats (which are read by them), and Expires, ExpiryWarned and
+	// Highlight (which are written by them). All other fields are only used
+	// by the goroutine watching the host, which is also the only one which
+	// saves it (see Host.save).
+	mu            sync.Mutex
 	Online        bool
 	LastOnline    time.Time
 	LastOffline   time.Time
 	TotalDowntime time.Duration
+
+	Stats    LatencyStats
+	Degraded bool
+
+	// Cadence controls how often the host is probed, and how many probes
+	// must fail for it to be considered offline.
+	Cadence ProbeConfig
+
+	// Unreachable is true if the host went offline while its parent was
+	// offline (see Host.parent).
+	Unreachable bool
+
+	// Incident is the id of the incident the host is part of, if any (see
+	// Host.correlate).
+	Incident int
+
+	// Maint is the maintenance window the host is in, if any (see
+	// Host.checkMaintenance).
+	Maint *MaintState
+
+	// Flap detection state (see Host.flap).
+	Transitions []time.Time
+	Flapping    bool
+	FlapStart   time.Time
+	FlapCount   int
+	LastDigest  time.Time
+}
+
+// setOnline updates the state of the host.
+func (h *Host) setOnline(online bool) {
+	h.mu.Lock()
+	h.Online = online
+	h.mu.Unlock()
+}
+
+// isOnline returns the state of the host, and is safe to use from other
+// goroutines.
+func (h *Host) isOnline() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.Online
+}
+
+// addStats adds a probe result to the stats of the host.
+func (h *Host) addStats(result *ProbeResult) {
+	h.mu.Lock()
+	h.Stats.Add(result)
+	h.mu.Unlock()
+}
+
+// statsString returns the stats of the host, and is safe to use from other
+// goroutines.
+func (h *Host) statsString() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.Stats.String()
+}
+
+// Addr returns the address being checked, for display purposes.
+func (h *Host) Addr() string {
+	// Not all probes resolve an ip (e.g. when going through a proxy).
+	addr := h.Probe.Target()
+	if h.IP != nil {
+		addr = h.IP.String()
+	}
+
+	if multi, ok := h.Probe.(*MultiProbe); ok {
+		return fmt.Sprintf("%s (+%d)", addr, len(multi.Probes)-1)
+	}
+
+	return addr
 }
 
 func (h *Host) Send(text string) {
@@ -218,105 +350,242 @@ func (h *Host) Send(text string) {
 		h.HasSentFirstReply = true
 	}
 
-	if len(h.Highlight) > 0 {
-		text = "<@" + strings.Join(h.Highlight, "> <@") + ">: " + text
+	h.mu.Lock()
+	highlight := h.Highlight
+	h.mu.Unlock()
+
+	if len(highlight) > 0 {
+		t := getTransport(h.Origin.Transport)
+
+		var mentions []string
+		for _, uid := range highlight {
+			mentions = append(mentions, t.Mention(uid))
+		}
+
+		text = strings.Join(mentions, " ") + ": " + text
 	}
 
-	slackReply(h.Origin, true, text)
+	replyTo(h.Origin, true, text)
 }
 
 func (h *Host) Sendf(format string, v ...interface{}) {
 	h.Send(fmt.Sprintf(format, v...))
 }
 
+// probeTimeout is the maximum amount of time a single probe run can take.
+const probeTimeout = time.Minute
+
+// check runs the hosts probe once, cancelling it early if the host is
+// removed.
+func (h *Host) check() *ProbeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-h.closer:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return h.Probe.Run(ctx)
+}
+
 func (h *Host) Watch() {
 	defer hostGroup.LRemove(h.ID, "")
+	defer h.resolveIncident(true)
+
+	// If the check has been resumed after a restart, continue from the
+	// previously stored state.
+	if h.LastOnline.IsZero() && h.LastOffline.IsZero() {
+		h.first()
+		h.save()
+	}
+
+	for {
+		select {
+		case <-h.closer:
+			return
+		case <-time.After(h.Cadence.Interval.Duration):
+			if !h.cycle() {
+				return
+			}
+
+			h.save()
+		}
+	}
+}
+
+// first runs the initial probe, to determine the starting state of the host.
+func (h *Host) first() {
+	first := h.check()
+	h.addStats(first)
+	if first.Notice != "" {
+		h.Send(first.Notice)
+	}
 
-	first := ping.Pinger(h.IP.String(), 2)
-	if first == nil {
+	if first.Online() {
 		if conf.NotifyOnStart {
-			h.Sendf("%s online :white_check_mark:", h.IP.String())
+			h.Sendf("%s online%s :white_check_mark:", h.Probe.Target(), first.suffix())
 		}
-		h.Online = true
+		h.setOnline(true)
 		h.LastOnline = time.Now()
 	} else {
 		if conf.NotifyOnStart {
-			h.Sendf("%s offline :warn1:", h.IP.String())
+			h.Sendf("%s offline%s :warn1:", h.Probe.Target(), first.suffix())
 		}
-		h.Online = false
+		h.setOnline(false)
 		h.LastOffline = time.Now()
 	}
 
-	for {
+	h.record(eventStart, h.Buffer, first)
+}
+
+// cycle runs a single round of probes against the host, updating its state
+// and notifying of any changes. It returns false if the host should no
+// longer be watched.
+func (h *Host) cycle() bool {
+	if !h.checkExpiry() {
+		return false
+	}
+
+	h.syncIncident()
+	h.checkMaintenance()
+	if h.Maint == nil {
+		h.checkFlapping()
+	}
+
+	var last, lastBad *ProbeResult
+	var bad int
+	for i := 0; i < h.Cadence.Attempts; i++ {
 		select {
 		case <-h.closer:
-			return
-		case <-time.After(5 * time.Second):
-			if time.Since(h.Added) > time.Duration(conf.ForcedTimeout)*time.Second {
-				hostGroup.LRemove(h.ID, fmt.Sprintf("stopped monitoring %s: checks exceeded `%s`", h.IP, time.Duration(conf.ForcedTimeout)*time.Second))
-				return
-			}
+			return false
+		case <-time.After(h.Cadence.AttemptInterval.Duration):
+		}
 
-			var check error
-			var bad int
-			for i := 0; i < 3; i++ {
-				select {
-				case <-h.closer:
-					return
-				case <-time.After(2 * time.Second):
-				}
+		logger.Printf("probing %s via %s [%d/%d]", h.Probe.Target(), h.Probe.Name(), i+1, h.Cadence.Attempts)
+		result := h.check()
+		h.addStats(result)
+		h.record(eventResult, "", result)
+		if result.Notice != "" && h.Maint == nil {
+			h.Send(result.Notice)
+		}
 
-				logger.Printf("pinging %s [%d/3]", h.IP.String(), i+1)
-				check = ping.Pinger(h.IP.String(), 2)
-				if check != nil {
-					bad++
-				}
-			}
+		if result.Err != nil {
+			bad++
+			lastBad = result
+			continue
+		}
 
-			if bad < 3 {
-				check = nil
-			}
+		last = result
+	}
 
-			if check == nil {
-				if h.Online {
-					// Host is still online.
-				} else {
-					// Host has become online.
-					h.Online = true
+	// The host is only offline if enough probes in the round failed,
+	// regardless of their order.
+	if bad < h.Cadence.Fails {
+		if h.Online {
+			// Host is still online.
+		} else {
+			// Host has become online.
+			h.setOnline(true)
 
-					// Add up the downtime.
-					h.TotalDowntime += time.Since(h.LastOffline)
+			// Don't count the outage towards the loss thresholds.
+			h.mu.Lock()
+			h.Stats.Recent = nil
+			h.mu.Unlock()
 
-					h.Sendf("%s now online (downtime: `%s`) :white_check_mark:", h.IP, h.TotalDowntime.Truncate(time.Second))
+			// Add up the downtime.
+			h.TotalDowntime += time.Since(h.LastOffline)
+
+			if !h.suppress() {
+				if h.Unreachable {
+					h.Sendf("%s reachable again%s (downtime: `%s`) :white_check_mark:", h.Probe.Target(), last.suffix(), h.TotalDowntime.Truncate(time.Second))
+				} else {
+					h.Sendf("%s now online%s (downtime: `%s`) :white_check_mark:", h.Probe.Target(), last.suffix(), h.TotalDowntime.Truncate(time.Second))
 				}
+			}
+			h.Unreachable = false
+			h.resolveIncident(false)
+			h.record(eventOnline, "", last)
+		}
 
-				h.LastOnline = time.Now()
+		h.LastOnline = time.Now()
+		h.checkDegraded()
 
-				if (h.LastOffline.IsZero() && time.Since(h.Added) > time.Duration(conf.RemovalTimeout)*time.Second) ||
-					(!h.LastOffline.IsZero() && time.Since(h.LastOffline) > time.Duration(conf.RemovalTimeout)*time.Second) {
-					hostGroup.LRemove(h.ID, fmt.Sprintf("stopped monitoring %s: time since last offline `>%s`", h.IP, time.Duration(conf.RemovalTimeout)*time.Second))
-					return
-				}
+		if removal := h.removalTimeout(); removal > 0 &&
+			((h.LastOffline.IsZero() && time.Since(h.Added) > removal) ||
+				(!h.LastOffline.IsZero() && time.Since(h.LastOffline) > removal)) {
+			hostGroup.LRemove(h.ID, fmt.Sprintf("stopped monitoring %s: time since last offline `>%s`", h.Probe.Target(), removal))
+			return false
+		}
 
-				// Since it's healthy, wait a bit before trying to check if it's offline.
-				// This should help prevent a bit of spam if the service is flapping.
-				time.Sleep(25 * time.Second)
-				continue
-			}
+		// Since it's healthy, wait a bit before trying to check if it's offline.
+		// This should help prevent a bit of spam if the service is flapping
+		// (in addition to flap detection, see Host.flap).
+		select {
+		case <-h.closer:
+			return false
+		case <-time.After(h.Cadence.HealthyDelay.Duration):
+		}
 
-			// Assume host offline past this point.
+		return true
+	}
+
+	// Assume host offline past this point.
 
-			if h.Online {
-				// Host was previously online, and is now offline.
-				h.Online = false
+	if h.Online {
+		// Host was previously online, and is now offline.
+		h.setOnline(false)
+
+		// If the host depends on another which is also offline, it's
+		// unreachable rather than offline.
+		if parent := h.parent(); parent != "" && h.parentDown(parent) {
+			h.Unreachable = true
+		}
 
-				h.Sendf("%s now offline :warn1:", h.IP)
+		if !h.suppress() && !h.correlate() {
+			if h.Unreachable {
+				h.Sendf("%s unreachable (parent `%s` down)%s :warn1:", h.Probe.Target(), h.parent(), lastBad.suffix())
 			} else {
-				// Host is still offline.
-				h.TotalDowntime += time.Since(h.LastOffline)
+				h.Sendf("%s now offline%s :warn1:", h.Probe.Target(), lastBad.suffix())
 			}
+		}
 
-			h.LastOffline = time.Now()
+		if h.Unreachable {
+			h.record(eventUnreachable, h.parent(), lastBad)
+		} else {
+			h.record(eventOffline, "", lastBad)
 		}
+	} else {
+		// Host is still offline.
+		h.TotalDowntime += time.Since(h.LastOffline)
+	}
+
+	h.LastOffline = time.Now()
+	return true
+}
+
+// checkDegraded notifies if the host has crossed (or recovered from) the
+// configured loss/latency thresholds.
+func (h *Host) checkDegraded() {
+	if h.Maint != nil {
+		return
+	}
+
+	reason := h.Stats.Degraded()
+
+	if reason != "" && !h.Degraded {
+		h.Degraded = true
+		h.Sendf("%s degraded: %s :warn1:", h.Probe.Target(), reason)
+		h.record(eventDegraded, reason, nil)
+		return
+	}
+
+	if reason == "" && h.Degraded {
+		h.Degraded = false
+		h.Sendf("%s no longer degraded (`%s`) :white_check_mark:", h.Probe.Target(), &h.Stats)
+		h.record(eventRecovered, h.Stats.String(), nil)
 	}
 }
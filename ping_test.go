@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedProbe returns the results of a script, where each "S" is a
+// successful probe and "F" a failed one.
+type scriptedProbe struct {
+	script string
+	runs   int
+}
+
+func (p *scriptedProbe) Name() string   { return "scripted" }
+func (p *scriptedProbe) Target() string { return "web01" }
+
+func (p *scriptedProbe) Run(ctx context.Context) *ProbeResult {
+	result := &ProbeResult{}
+	if p.script[p.runs%len(p.script)] == 'F' {
+		result.Err = errors.New("timed out")
+	}
+	p.runs++
+
+	return result
+}
+
+func TestCycle(t *testing.T) {
+	defer func(c Config, def Transport) {
+		conf = c
+		transports.Lock()
+		transports.def = def
+		transports.Unlock()
+	}(conf, transports.def)
+
+	conf.ForcedTimeout = 3600
+	conf.Correlation.MinHosts = -1
+	conf.Flap.Threshold = -1
+
+	out := &bytes.Buffer{}
+	transports.Lock()
+	transports.def = &consoleTransport{out: out, messages: make(map[string]*Message)}
+	transports.Unlock()
+
+	tests := []struct {
+		online bool
+		script string
+		fails  int
+		want   bool
+		// notice is the expected notification, if any.
+		notice string
+	}{
+		{true, "SSSSS", 2, true, ""},
+		{true, "SFSSS", 2, true, ""},
+		// Failures don't need to be consecutive, or the last probes.
+		{true, "FFSSS", 2, false, "web01 now offline"},
+		{true, "SFSFS", 2, false, "web01 now offline"},
+		{true, "SFSFS", 3, true, ""},
+		{true, "FFFFF", 5, false, "web01 now offline"},
+		{false, "FFFFF", 2, false, ""},
+		{false, "SSSSF", 2, true, "web01 now online"},
+		{false, "FSFSS", 2, false, ""},
+		{false, "SSSSF", 1, false, ""},
+	}
+
+	for _, tt := range tests {
+		out.Reset()
+
+		h := &Host{
+			ID:             "web01",
+			Probe:          &scriptedProbe{script: tt.script},
+			Origin:         &Message{Transport: "console", Channel: "ops", Timestamp: "1"},
+			Added:          time.Now(),
+			Online:         tt.online,
+			LastOnline:     time.Now(),
+			RemovalTimeout: -1,
+			Cadence:        ProbeConfig{Attempts: len(tt.script), Fails: tt.fails},
+			closer:         make(chan struct{}, 1),
+		}
+		if !tt.online {
+			h.LastOffline = time.Now()
+		}
+
+		if !h.cycle() {
+			t.Errorf("cycle() with %s (fails=%d) stopped watching", tt.script, tt.fails)
+			continue
+		}
+
+		if h.Online != tt.want {
+			t.Errorf("cycle() with %s (fails=%d) from online=%t gave online=%t, want %t", tt.script, tt.fails, tt.online, h.Online, tt.want)
+		}
+
+		if tt.notice == "" && out.Len() > 0 || !strings.Contains(out.String(), tt.notice) {
+			t.Errorf("cycle() with %s (fails=%d) from online=%t notified %q, want %q", tt.script, tt.fails, tt.online, out.String(), tt.notice)
+		}
+	}
+}
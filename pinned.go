@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CheckConfig is a pinned check, defined in the configuration. Pinned checks
+// are started on boot, never expire, and report to their channel directly
+// (rather than in a thread).
+type CheckConfig struct {
+	// Target is the host, ip, host:port, url, etc, to check (see newProbe).
+	Target string `toml:"target"`
+	// Probe is the type of probe to use (icmp, tcp, url, tls or dns). If
+	// empty, it's determined from the target.
+	Probe string `toml:"probe"`
+	// Transport is the transport to report through (e.g. "slack"),
+	// defaulting to the default transport.
+	Transport string `toml:"transport"`
+	// Channel is the channel to report to, defaulting to the incoming
+	// channel of the transport.
+	Channel string `toml:"channel"`
+	// Interval overrides the interval between each round of probes.
+	Interval Duration `toml:"interval"`
+	// Options are probe and cadence options, as supported by !check.
+	Options map[string]string `toml:"options"`
+}
+
+// query returns the query used to create the probe for the check.
+func (c *CheckConfig) query() string {
+	target := c.Target
+
+	switch strings.ToLower(c.Probe) {
+	case "tls", "dns":
+		if !strings.Contains(target, "://") {
+			target = strings.ToLower(c.Probe) + "://" + target
+		}
+	}
+
+	return target
+}
+
+// startPinnedChecks starts watching all checks defined in the configuration,
+// returning the amount of checks which were started.
+func startPinnedChecks() (started int) {
+	for i := range conf.Checks {
+		if err := startPinnedCheck(&conf.Checks[i]); err != nil {
+			logger.Printf("unable to start pinned check %q: %s", conf.Checks[i].Target, err)
+			continue
+		}
+
+		started++
+	}
+
+	return started
+}
+
+func startPinnedCheck(c *CheckConfig) error {
+	query := c.query()
+	opts := Options(c.Options)
+
+	probe, ip, err := newProbe(query, opts)
+	if err != nil {
+		return err
+	}
+
+	if c.Probe != "" && !strings.EqualFold(probe.Name(), c.Probe) {
+		return fmt.Errorf("target is not a %s check (detected %s)", c.Probe, probe.Name())
+	}
+
+	cadence := conf.Probe
+	if err = cadence.Apply(opts); err != nil {
+		return err
+	}
+
+	if c.Interval.Duration > 0 {
+		cadence.Interval = c.Interval
+	}
+
+	t := getTransport(c.Transport)
+
+	channel := c.Channel
+	if channel == "" {
+		channel = t.IncomingChannel()
+	}
+
+	channelID, err := t.ChannelID(channel)
+	if err != nil {
+		return err
+	}
+
+	host := &Host{
+		closer:         make(chan struct{}, 1),
+		Origin:         &Message{Transport: t.Name(), Channel: channelID},
+		IP:             ip,
+		Query:          query,
+		Probe:          probe,
+		Added:          time.Now(),
+		Buffer:         "pinned via config",
+		Highlight:      []string{},
+		Cadence:        cadence,
+		Options:        opts,
+		Pinned:         true,
+		RemovalTimeout: -1,
+	}
+
+	if err = hostGroup.Add(query, host); err != nil {
+		return err
+	}
+
+	go host.Watch()
+	return nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulstuart/ping"
+)
+
+// Probe is a single type of check which can be ran against a target (e.g.
+// ICMP, a TCP port, etc).
+type Probe interface {
+	// Name is the short name of the probe type (e.g. "icmp").
+	Name() string
+	// Target is the address or resource being probed.
+	Target() string
+	// Run executes the probe once. It should return once the check has
+	// completed, or the context has been cancelled.
+	Run(ctx context.Context) *ProbeResult
+}
+
+// ProbeResult is the result of a single probe run.
+type ProbeResult struct {
+	// Latency is how long the probe took to run, including any setup (e.g.
+	// opening a socket, or resolving a hostname). It's not a round trip
+	// time.
+	Latency time.Duration
+	Err     error
+	// Detail is optional extra information about the result, e.g. the
+	// banner of a TCP service.
+	Detail string
+	// Notice is an optional message which should be relayed to the users
+	// watching the probe, regardless of the state of the check (e.g. a
+	// certificate has changed).
+	Notice string
+}
+
+// Online returns true if the probe was successful.
+func (r *ProbeResult) Online() bool {
+	return r.Err == nil
+}
+
+// suffix returns the detail of the result, formatted to be appended to
+// a status message.
+func (r *ProbeResult) suffix() string {
+	if r == nil || r.Detail == "" {
+		return ""
+	}
+
+	return " (`" + r.Detail + "`)"
+}
+
+// ICMPProbe checks if a host responds to ICMP (or ICMPv6) echo requests.
+type ICMPProbe struct {
+	IP net.IP
+}
+
+func (p *ICMPProbe) Name() string   { return "icmp" }
+func (p *ICMPProbe) Target() string { return p.IP.String() }
+
+func (p *ICMPProbe) Run(ctx context.Context) *ProbeResult {
+	if p.IP.To4() == nil {
+		start := time.Now()
+		err := pingv6(ctx, p.IP, 2*time.Second)
+		return &ProbeResult{Latency: time.Since(start), Err: err}
+	}
+
+	done := make(chan *ProbeResult, 1)
+
+	go func() {
+		start := time.Now()
+		err := ping.Pinger(p.IP.String(), 2)
+		done <- &ProbeResult{Latency: time.Since(start), Err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return &ProbeResult{Err: ctx.Err()}
+	case result := <-done:
+		return result
+	}
+}
+
+// bannerTimeout is how long to wait for a service to send a banner, after
+// connecting.
+const bannerTimeout = 2 * time.Second
+
+// TCPProbe checks if a TCP port accepts connections, optionally reading the
+// banner sent by the service (e.g. SSH, SMTP, etc).
+type TCPProbe struct {
+	Addr   string
+	Banner bool
+}
+
+func (p *TCPProbe) Name() string   { return "tcp" }
+func (p *TCPProbe) Target() string { return p.Addr }
+
+func (p *TCPProbe) Run(ctx context.Context) *ProbeResult {
+	var dialer net.Dialer
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return &ProbeResult{Err: err}
+	}
+	defer conn.Close()
+
+	result := &ProbeResult{Latency: time.Since(start)}
+
+	if !p.Banner {
+		return result
+	}
+
+	// Not all services send a banner, so a failed read isn't considered
+	// a failed check.
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(bannerTimeout))
+	n, _ := conn.Read(buf)
+	if n > 0 {
+		result.Detail = strings.TrimSpace(strings.SplitN(string(buf[:n]), "\n", 2)[0])
+	}
+
+	return result
+}
+
+// splitHostPort is like net.SplitHostPort, however the port is optional.
+// IPv6 addresses may be bracketed (e.g. "[2001:db8::1]" or
+// "[2001:db8::1]:443").
+func splitHostPort(query string) (host, port string) {
+	host, port, err := net.SplitHostPort(query)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(query, "["), "]"), ""
+	}
+
+	return host, port
+}
+
+// resolve returns the address of the provided ip or hostname.
+func resolve(host string) (net.IP, error) {
+	addrs, err := resolveAll(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return addrs[0], nil
+}
+
+// resolveAll returns all addresses of the provided ip or hostname.
+func resolveAll(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	return net.LookupIP(host)
+}
+
+// probeOptions are the options supported by each type of probe, in addition
+// to the cadence options (see ProbeConfig.Set), lifetime options (see
+// Host.setLifetime) and dependency options (see Host.parent), which apply to
+// all probes.
+var probeOptions = map[string][]string{
+	"icmp": nil,
+	"tcp":  {"banner"},
+	"url":  {"method", "status", "match", "regex", "redirects", "timeout"},
+	"tls":  {"threshold"},
+	"dns":  {"type", "resolver"},
+}
+
+// checkOptions returns an error if any of the provided options aren't
+// supported by the provided type of probe.
+func checkOptions(name string, opts Options) error {
+	var supported []string
+	supported = append(supported, probeOptions[name]...)
+	supported = append(supported, cadenceOptions...)
+	supported = append(supported, lifetimeOptions...)
+	supported = append(supported, dependencyOptions...)
+
+	for key := range opts {
+		var ok bool
+		for _, s := range supported {
+			if key == s {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return fmt.Errorf("unknown option for %s check: %q", name, key)
+		}
+	}
+
+	return nil
+}
+
+// newProbe returns the probe best suited for the provided query, which can
+// be an ip or hostname (ICMP), an ip:port or hostname:port (TCP), an http(s)
+// url, tls://host[:port], or a dns uri (see newDNSProbe). It also returns the
+// resolved address of the query, if there is one. opts override the defaults
+// from the configuration, and may be nil.
+func newProbe(query string, opts Options) (probe Probe, ip net.IP, err error) {
+	defer func() {
+		if err == nil {
+			err = checkOptions(probe.Name(), opts)
+		}
+	}()
+
+	if strings.HasPrefix(strings.ToLower(query), "tls://") {
+		return newTLSProbe(query[len("tls://"):], opts)
+	}
+
+	if strings.HasPrefix(strings.ToLower(query), "dns://") {
+		probe, err := newDNSProbe(query, opts)
+		return probe, nil, err
+	}
+
+	if uri, err := url.Parse(query); err == nil && (uri.Scheme == "http" || uri.Scheme == "https") {
+		ip, err := resolve(uri.Hostname())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		probe, err := newURLProbe(query, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return probe, ip, nil
+	}
+
+	host, port := splitHostPort(query)
+
+	addrs, err := resolveAll(host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, nil, fmt.Errorf("invalid port: %q", port)
+		}
+	}
+
+	banner, err := opts.Bool("banner", conf.TCP.ReadBanner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	probes := make([]Probe, len(addrs))
+	for i, ip := range addrs {
+		if port == "" {
+			probes[i] = &ICMPProbe{IP: ip}
+			continue
+		}
+
+		probes[i] = &TCPProbe{Addr: net.JoinHostPort(ip.String(), port), Banner: banner}
+	}
+
+	if len(probes) == 1 {
+		return probes[0], addrs[0], nil
+	}
+
+	// Hostnames which resolve to multiple addresses are checked as a single
+	// logical check.
+	target := host
+	if port != "" {
+		target = net.JoinHostPort(host, port)
+	}
+
+	return &MultiProbe{Host: target, Probes: probes}, addrs[0], nil
+}
+
+// MultiProbe runs a set of probes (e.g. one for each address a hostname
+// resolves to) as a single logical check. The check is considered online if
+// at least one of the probes is successful, and changes to the state of
+// individual probes are reported as notices.
+type MultiProbe struct {
+	Host   string
+	Probes []Probe
+
+	status map[string]bool
+}
+
+func (p *MultiProbe) Name() string   { return p.Probes[0].Name() }
+func (p *MultiProbe) Target() string { return p.Host }
+
+func (p *MultiProbe) Run(ctx context.Context) *ProbeResult {
+	results := make([]*ProbeResult, len(p.Probes))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range p.Probes {
+		wg.Add(1)
+		go func(i int) {
+			results[i] = p.Probes[i].Run(ctx)
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	var up int
+	for _, result := range results {
+		if result.Online() {
+			up++
+		}
+	}
+
+	summary := fmt.Sprintf("%d/%d addresses up", up, len(results))
+	result := &ProbeResult{Latency: time.Since(start), Detail: summary}
+	if up == 0 {
+		result.Err = errors.New(summary)
+	}
+
+	var notices []string
+	first := p.status == nil
+	if first {
+		p.status = make(map[string]bool)
+	}
+
+	for i, probe := range p.Probes {
+		online := results[i].Online()
+
+		if last, ok := p.status[probe.Target()]; (ok && last != online) || (!ok && !online) {
+			state := "online"
+			if !online {
+				state = "offline"
+			}
+
+			if first {
+				notices = append(notices, fmt.Sprintf("%s (%s) %s", p.Host, probe.Target(), state))
+			} else {
+				notices = append(notices, fmt.Sprintf("%s (%s) now %s", p.Host, probe.Target(), state))
+			}
+		}
+
+		p.status[probe.Target()] = online
+	}
+
+	if len(notices) > 0 {
+		result.Notice = strings.Join(notices, "\n") + " (" + summary + ")"
+	}
+
+	return result
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNSProbe periodically resolves a name, notifying when the answers change.
+// Resolution failures (or no answers) are considered a failed check.
+type DNSProbe struct {
+	Domain string
+	// Type is the record type to resolve: A, AAAA, CNAME, MX or TXT.
+	Type string
+	// Resolver is an optional resolver address (ip[:port]) to query, rather
+	// than the system resolver.
+	Resolver string
+
+	last []string
+}
+
+// newDNSProbe parses a dns uri in the form of "dns://[resolver/]name[?type=A]",
+// as described in RFC 4501. The "type" and "resolver" options take precedence
+// over the uri.
+func newDNSProbe(query string, opts Options) (*DNSProbe, error) {
+	uri, err := url.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &DNSProbe{Domain: strings.TrimPrefix(uri.Path, "/"), Resolver: uri.Host, Type: "A"}
+	if p.Domain == "" {
+		// No resolver, so the name is in the host portion.
+		p.Domain, p.Resolver = uri.Host, ""
+	}
+
+	if t := opts.String("type", uri.Query().Get("type")); t != "" {
+		p.Type = strings.ToUpper(t)
+	}
+
+	p.Resolver = opts.String("resolver", p.Resolver)
+
+	switch p.Type {
+	case "A", "AAAA", "CNAME", "MX", "TXT":
+	default:
+		return nil, fmt.Errorf("unsupported record type: %q", p.Type)
+	}
+
+	if p.Domain == "" {
+		return nil, errors.New("no name supplied")
+	}
+
+	return p, nil
+}
+
+func (p *DNSProbe) Name() string { return "dns" }
+
+func (p *DNSProbe) Target() string {
+	if p.Resolver != "" {
+		return "dns://" + p.Resolver + "/" + p.Domain + "?type=" + p.Type
+	}
+
+	return "dns://" + p.Domain + "?type=" + p.Type
+}
+
+func (p *DNSProbe) resolver() *net.Resolver {
+	if p.Resolver == "" {
+		return net.DefaultResolver
+	}
+
+	addr := p.Resolver
+	if host, port := splitHostPort(addr); port == "" {
+		// host has any brackets around ipv6 addresses removed.
+		addr = net.JoinHostPort(host, "53")
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// Lookup resolves the name, returning the sorted answers.
+func (p *DNSProbe) Lookup(ctx context.Context) (answers []string, err error) {
+	r := p.resolver()
+
+	switch p.Type {
+	case "A", "AAAA":
+		var addrs []net.IPAddr
+		if addrs, err = r.LookupIPAddr(ctx, p.Domain); err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if (addr.IP.To4() != nil) == (p.Type == "A") {
+				answers = append(answers, addr.IP.String())
+			}
+		}
+	case "CNAME":
+		var cname string
+		if cname, err = r.LookupCNAME(ctx, p.Domain); err != nil {
+			return nil, err
+		}
+
+		answers = append(answers, cname)
+	case "MX":
+		var records []*net.MX
+		if records, err = r.LookupMX(ctx, p.Domain); err != nil {
+			return nil, err
+		}
+
+		for _, mx := range records {
+			answers = append(answers, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+	case "TXT":
+		if answers, err = r.LookupTXT(ctx, p.Domain); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(answers) == 0 {
+		return nil, fmt.Errorf("no %s records found for %s", p.Type, p.Domain)
+	}
+
+	sort.Strings(answers)
+	return answers, nil
+}
+
+func (p *DNSProbe) Run(ctx context.Context) *ProbeResult {
+	start := time.Now()
+	answers, err := p.Lookup(ctx)
+	if err != nil {
+		return &ProbeResult{Err: err}
+	}
+
+	result := &ProbeResult{Latency: time.Since(start), Detail: strings.Join(answers, ", ")}
+
+	if p.last != nil && strings.Join(p.last, "\n") != strings.Join(answers, "\n") {
+		result.Notice = fmt.Sprintf(
+			"%s %s records changed:\n```\n- %s\n+ %s\n```",
+			p.Domain, p.Type, strings.Join(p.last, "\n- "), strings.Join(answers, "\n+ "),
+		)
+	}
+	p.last = answers
+
+	return result
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// TLSProbe completes a TLS handshake with the target, and validates the
+// certificate that it presents. Changes to the certificate, and certificates
+// which are close to expiring, are reported as notices.
+type TLSProbe struct {
+	Addr       string
+	ServerName string
+	// ExpiryThreshold is the number of days before the certificate expires,
+	// where a notice should be sent.
+	ExpiryThreshold int
+
+	fingerprint string
+	warned      bool
+}
+
+// CertInfo is information about the certificate presented by a TLS server.
+type CertInfo struct {
+	Subject     string
+	SANs        []string
+	Issuer      string
+	NotAfter    time.Time
+	Fingerprint string
+	VerifyErr   error
+}
+
+// DaysLeft returns the amount of days until the certificate expires.
+func (c *CertInfo) DaysLeft() int {
+	return int(time.Until(c.NotAfter).Hours() / 24)
+}
+
+func (c *CertInfo) String() string {
+	out := fmt.Sprintf(
+		"subject: %s\nsans:    %s\nissuer:  %s\nexpires: %s (%d days)\nsha256:  %s\n",
+		c.Subject, strings.Join(c.SANs, ", "), c.Issuer, c.NotAfter.Format(time.RFC1123), c.DaysLeft(), c.Fingerprint,
+	)
+
+	if c.VerifyErr != nil {
+		out += "verify:  " + c.VerifyErr.Error() + "\n"
+	} else {
+		out += "verify:  ok\n"
+	}
+
+	return out
+}
+
+// newTLSProbe returns a TLSProbe for the provided host, with an optional
+// port (defaulting to 443). The expiry threshold (in days) can be overridden
+// with the "threshold" option.
+func newTLSProbe(query string, opts Options) (*TLSProbe, net.IP, error) {
+	host, port := splitHostPort(query)
+	if port == "" {
+		port = "443"
+	}
+
+	threshold, err := opts.Int("threshold", conf.TLS.ExpiryThresholdDays)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ip, err := resolve(host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &TLSProbe{
+		Addr:            net.JoinHostPort(ip.String(), port),
+		ServerName:      host,
+		ExpiryThreshold: threshold,
+	}, ip, nil
+}
+
+func (p *TLSProbe) Name() string { return "tls" }
+
+func (p *TLSProbe) Target() string {
+	_, port := splitHostPort(p.Addr)
+	return "tls://" + net.JoinHostPort(p.ServerName, port)
+}
+
+// Inspect connects to the target, completing a TLS handshake, and returns
+// information about the certificate it presents.
+func (p *TLSProbe) Inspect(ctx context.Context) (*CertInfo, error) {
+	var dialer net.Dialer
+
+	rawConn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer rawConn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		rawConn.SetDeadline(deadline)
+	}
+
+	// Verification is done manually below, so we can still report on
+	// certificates which are invalid.
+	conn := tls.Client(rawConn, &tls.Config{ServerName: p.ServerName, InsecureSkipVerify: true})
+	if err = conn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates presented by %s", p.ServerName)
+	}
+
+	sum := sha256.Sum256(certs[0].Raw)
+	info := &CertInfo{
+		Subject:     certs[0].Subject.CommonName,
+		SANs:        certs[0].DNSNames,
+		Issuer:      certs[0].Issuer.CommonName,
+		NotAfter:    certs[0].NotAfter,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+
+	for _, ip := range certs[0].IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+
+	opts := x509.VerifyOptions{DNSName: p.ServerName, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, info.VerifyErr = certs[0].Verify(opts)
+
+	return info, nil
+}
+
+func (p *TLSProbe) Run(ctx context.Context) *ProbeResult {
+	start := time.Now()
+	info, err := p.Inspect(ctx)
+	if err != nil {
+		return &ProbeResult{Err: err}
+	}
+
+	result := &ProbeResult{
+		Latency: time.Since(start),
+		Err:     info.VerifyErr,
+		Detail:  fmt.Sprintf("%s, expires in %d days", info.Subject, info.DaysLeft()),
+	}
+
+	if info.VerifyErr != nil {
+		result.Detail += ", " + info.VerifyErr.Error()
+	}
+
+	var notices []string
+
+	if p.fingerprint != "" && p.fingerprint != info.Fingerprint {
+		notices = append(notices, fmt.Sprintf("%s certificate changed:\n```\n%s```", p.Target(), info))
+	}
+	p.fingerprint = info.Fingerprint
+
+	if days := info.DaysLeft(); days <= p.ExpiryThreshold {
+		if !p.warned {
+			notices = append(notices, fmt.Sprintf("%s certificate expires in `%d` days (%s) :warn1:", p.Target(), days, info.NotAfter.Format(time.RFC1123)))
+			p.warned = true
+		}
+	} else {
+		p.warned = false
+	}
+
+	result.Notice = strings.Join(notices, "\n")
+
+	return result
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodySize is the maximum amount of the response body that is read when
+// matching against the body.
+const maxBodySize = 1 << 20
+
+// URLProbe checks if an HTTP(S) endpoint responds with the expected status
+// code, and optionally, the expected content.
+type URLProbe struct {
+	URL             string
+	Method          string
+	MinStatus       int
+	MaxStatus       int
+	Match           string
+	Regex           *regexp.Regexp
+	FollowRedirects bool
+	Timeout         time.Duration
+}
+
+// newURLProbe returns a new URLProbe for the given url, using the defaults
+// from the configuration, unless overridden by opts.
+func newURLProbe(url string, opts Options) (*URLProbe, error) {
+	p := &URLProbe{
+		URL:     url,
+		Method:  strings.ToUpper(opts.String("method", conf.URL.Method)),
+		Match:   opts.String("match", conf.URL.BodyMatch),
+		Timeout: time.Duration(conf.URL.TimeoutSecs) * time.Second,
+	}
+
+	var err error
+	if p.FollowRedirects, err = opts.Bool("redirects", conf.URL.FollowRedirects); err != nil {
+		return nil, err
+	}
+
+	if timeout, ok := opts["timeout"]; ok {
+		if p.Timeout, err = parseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid value for timeout: %q", timeout)
+		}
+	}
+
+	if p.MinStatus, p.MaxStatus, err = parseStatusRange(opts.String("status", conf.URL.ExpectStatus)); err != nil {
+		return nil, err
+	}
+
+	if expr := opts.String("regex", conf.URL.BodyRegex); expr != "" {
+		if p.Regex, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("invalid body regex: %s", err)
+		}
+	}
+
+	return p, nil
+}
+
+// parseStatusRange parses a status code range, e.g. "200-399" or "200".
+func parseStatusRange(in string) (min, max int, err error) {
+	parts := strings.SplitN(in, "-", 2)
+
+	if min, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("invalid status range: %q", in)
+	}
+
+	max = min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || max < min {
+			return 0, 0, fmt.Errorf("invalid status range: %q", in)
+		}
+	}
+
+	return min, max, nil
+}
+
+func (p *URLProbe) Name() string   { return "url" }
+func (p *URLProbe) Target() string { return p.URL }
+
+func (p *URLProbe) Run(ctx context.Context) *ProbeResult {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(p.Method, p.URL, nil)
+	if err != nil {
+		return &ProbeResult{Err: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "ponger")
+
+	client := &http.Client{}
+	if !p.FollowRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return &ProbeResult{Err: err}
+	}
+	defer resp.Body.Close()
+
+	result := &ProbeResult{Latency: time.Since(start)}
+	result.Detail = fmt.Sprintf("HTTP %d in %s", resp.StatusCode, result.Latency.Truncate(time.Millisecond))
+
+	if resp.StatusCode < p.MinStatus || resp.StatusCode > p.MaxStatus {
+		result.Err = fmt.Errorf("unexpected status: %s", resp.Status)
+		return result
+	}
+
+	if p.Match == "" && p.Regex == nil {
+		return result
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if p.Match != "" && !strings.Contains(string(body), p.Match) {
+		result.Err = fmt.Errorf("body does not contain %q", p.Match)
+		result.Detail += ", body mismatch"
+		return result
+	}
+
+	if p.Regex != nil && !p.Regex.Match(body) {
+		result.Err = fmt.Errorf("body does not match %q", p.Regex)
+		result.Detail += ", body mismatch"
+	}
+
+	return result
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportTimeFormat is the format used for timestamps within reports.
+const reportTimeFormat = "2006-01-02 15:04:05 MST"
+
+// report renders a Markdown report for the provided query, which is either
+// an incident (e.g. "#12"), or a check (see GetHistory), since the provided
+// time.
+func report(query string, since time.Time) (string, error) {
+	if strings.HasPrefix(query, "#") {
+		id, err := strconv.Atoi(query[1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid incident: %q", query)
+		}
+
+		return reportIncident(id)
+	}
+
+	return reportCheck(query, since)
+}
+
+// reportCheck renders a Markdown timeline of the check(s) matching the
+// provided query.
+func reportCheck(query string, since time.Time) (string, error) {
+	entries := GetHistory(query, since)
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no history for %q since %s", query, since.Format(reportTimeFormat))
+	}
+
+	out := fmt.Sprintf("# Report: `%s`\n\n", query)
+	out += fmt.Sprintf("From %s to %s.\n\n", since.Format(reportTimeFormat), time.Now().Format(reportTimeFormat))
+
+	// Checks may have been started more than once, so list each watch
+	// separately.
+	out += "## Watches\n\n"
+	var stats LatencyStats
+	for _, entry := range entries {
+		switch entry.Event {
+		case eventStart:
+			out += fmt.Sprintf(
+				"- `%s` (%s) started %s by %s (%s)%s\n",
+				entry.Target, entry.Addr, entry.Time.Format(reportTimeFormat), reportUser(entry.Transport, entry.User),
+				entry.Buffer, reportLink(entry.Transport, entry.Channel, entry.Thread),
+			)
+		case eventResult:
+			result := &ProbeResult{Latency: entry.Latency}
+			if entry.Error != "" {
+				result.Err = errors.New(entry.Error)
+			}
+			stats.Add(result)
+		}
+	}
+
+	var downtime time.Duration
+	outages := GetOutages(query, since)
+	for _, outage := range outages {
+		downtime += outage.Duration()
+	}
+
+	out += "\n## Summary\n\n"
+	out += fmt.Sprintf("- **Outages:** %d\n", len(outages))
+	out += fmt.Sprintf("- **Total downtime:** %s\n", downtime.Truncate(time.Second))
+	if stats.Sent > 0 {
+		out += fmt.Sprintf("- **Latency:** %s (%d probes)\n", &stats, stats.Sent)
+	}
+
+	out += "\n## Timeline\n\n"
+	out += reportTimeline(entries)
+
+	return out, nil
+}
+
+// reportIncident renders a Markdown timeline of the provided incident.
+func reportIncident(id int) (string, error) {
+	inc, err := GetIncident(id)
+	if err != nil {
+		return "", fmt.Errorf("unknown incident #%d", id)
+	}
+
+	out := fmt.Sprintf("# Report: incident #%d\n\n", inc.ID)
+	out += fmt.Sprintf("- **Opened:** %s\n", inc.Opened.Format(reportTimeFormat))
+	if inc.Closed.IsZero() {
+		out += "- **Closed:** still open\n"
+	} else {
+		out += fmt.Sprintf("- **Closed:** %s\n", inc.Closed.Format(reportTimeFormat))
+	}
+	out += fmt.Sprintf("- **Duration:** %s\n", inc.Duration().Truncate(time.Second))
+	if link := reportLink(inc.Transport, inc.Channel, inc.Message); link != "" {
+		out += fmt.Sprintf("- **Incident message:**%s\n", link)
+	}
+
+	out += "\n## Affected checks\n\n"
+	out += "| Check | Address | Down | Up | Downtime |\n"
+	out += "| --- | --- | --- | --- | --- |\n"
+
+	var entries []*HistoryEntry
+	until := inc.Closed
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	for _, ih := range inc.Hosts {
+		up, downtime := "still offline", time.Since(ih.Down)
+		switch {
+		case ih.Stopped:
+			up = "stopped"
+		case !ih.Up.IsZero():
+			up, downtime = ih.Up.Format(reportTimeFormat), ih.Up.Sub(ih.Down)
+		}
+
+		out += fmt.Sprintf(
+			"| `%s`%s | %s | %s | %s | %s |\n",
+			ih.Target, reportLink(ih.Transport, ih.Channel, ih.Thread), ih.Addr, ih.Down.Format(reportTimeFormat),
+			up, downtime.Truncate(time.Second),
+		)
+
+		for _, entry := range GetHistory(ih.Check, inc.Opened.Add(-conf.Correlation.Window.Duration)) {
+			if entry.Check == ih.Check && !entry.Time.After(until) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	out += "\n## Timeline\n\n"
+	out += reportTimeline(entries)
+
+	return out, nil
+}
+
+// reportTimeline renders the state transitions (and other notable events)
+// of the provided history entries as a Markdown table.
+func reportTimeline(entries []*HistoryEntry) (out string) {
+	out = "| Time | Check | Event | Detail |\n"
+	out += "| --- | --- | --- | --- |\n"
+
+	for _, entry := range entries {
+		if entry.Event == eventResult {
+			continue
+		}
+
+		detail := entry.Detail
+		if entry.Error != "" {
+			detail = strings.TrimSpace(detail + " " + entry.Error)
+		}
+		if entry.Event == eventStart || entry.Event == eventStop {
+			detail = strings.TrimSpace(detail + " " + reportUser(entry.Transport, entry.User))
+		}
+
+		out += fmt.Sprintf(
+			"| %s | `%s` | %s | %s |\n",
+			entry.Time.Format(reportTimeFormat), entry.Target, entry.Event,
+			strings.Replace(detail, "|", "\\|", -1),
+		)
+	}
+
+	return out
+}
+
+// reportUser returns the name of the provided user, for reports.
+func reportUser(transport, uid string) string {
+	if uid == "" {
+		return "config"
+	}
+
+	return userName(transport, uid)
+}
+
+// reportLink returns a Markdown link to the provided message, prefixed with
+// a space, or an empty string if a link can't be generated.
+func reportLink(transport, channel, ts string) string {
+	if link := getTransport(transport).Permalink(channel, ts); link != "" {
+		return fmt.Sprintf(" ([thread](%s))", link)
+	}
+
+	return ""
+}
@@ -18,7 +18,19 @@ func newSlackClient() *slack.Client {
 
 var lastConnectInfo *slack.Info
 
-func newSlackRTM(messageChan chan string) error {
+// slackTransport is the Slack transport, using the RTM api.
+type slackTransport struct{}
+
+func newSlackTransport() *slackTransport {
+	slack.SetLogger(logger)
+
+	return &slackTransport{}
+}
+
+func (s *slackTransport) Name() string            { return "slack" }
+func (s *slackTransport) IncomingChannel() string { return conf.IncomingChannel }
+
+func (s *slackTransport) Run(handler EventHandler) error {
 	channelID, err := lookupChannel(conf.IncomingChannel)
 	if err != nil {
 		return err
@@ -51,33 +63,40 @@ func newSlackRTM(messageChan chan string) error {
 			botID = ev.Info.User.ID
 
 			if firstConnection {
-				rtm.SendMessage(rtm.NewOutgoingMessage("_bot has been restarted (all checks flushed)_", channelID))
+				rtm.SendMessage(rtm.NewOutgoingMessage(restartMessage(), channelID))
 				firstConnection = false
 			}
 		case *slack.MessageEvent:
-			msgHandler(msg.Data, &slack.Message{Msg: ev.Msg, SubMessage: ev.SubMessage}, false, botID, "")
+			if ev.User == botID {
+				break
+			}
+
+			handler(s, &Event{Message: s.message(&ev.Msg)})
 		case *slack.ReactionAddedEvent:
-			if ev.Reaction != conf.ReactionTrigger {
+			if ev.Reaction != conf.ReactionTrigger || ev.User == botID {
 				break
 			}
 
 			hist := slackMsgFromReaction(ev.Item.Channel, ev.Item.Timestamp)
-			if hist == nil || len(hist.Messages) == 0 {
+			if hist == nil || len(hist.Messages) == 0 || hist.Messages[0].User == botID {
 				break
 			}
 
-			msgHandler(msg.Data, &hist.Messages[0], false, botID, ev.Reaction)
+			handler(s, &Event{Message: s.message(&hist.Messages[0].Msg), Reaction: ev.Reaction, ReactionUser: ev.User})
 		case *slack.ReactionRemovedEvent:
-			if ev.Reaction != conf.ReactionTrigger {
+			if ev.Reaction != conf.ReactionTrigger || ev.User == botID {
 				break
 			}
 
 			hist := slackMsgFromReaction(ev.Item.Channel, ev.Item.Timestamp)
-			if hist == nil || len(hist.Messages) == 0 {
+			if hist == nil || len(hist.Messages) == 0 || hist.Messages[0].User == botID {
 				break
 			}
 
-			msgHandler(msg.Data, &hist.Messages[0], true, botID, ev.Reaction)
+			handler(s, &Event{
+				Message: s.message(&hist.Messages[0].Msg), Reaction: ev.Reaction,
+				ReactionUser: ev.User, ReactionRemoved: true,
+			})
 		case *slack.RTMError:
 			return ev
 		case *slack.InvalidAuthEvent:
@@ -93,6 +112,75 @@ func newSlackRTM(messageChan chan string) error {
 	return nil
 }
 
+// message converts a Slack message into a Message.
+func (s *slackTransport) message(msg *slack.Msg) *Message {
+	return &Message{
+		Transport:       s.Name(),
+		Channel:         msg.Channel,
+		User:            msg.User,
+		Text:            msg.Text,
+		Timestamp:       msg.Timestamp,
+		ThreadTimestamp: msg.ThreadTimestamp,
+	}
+}
+
+func (s *slackTransport) Reply(msg *Message, thread bool, text string) error {
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	params.EscapeText = false
+
+	if thread {
+		params.ThreadTimestamp = msg.ThreadTimestamp
+		if params.ThreadTimestamp == "" {
+			params.ThreadTimestamp = msg.Timestamp
+		}
+	}
+
+	_, _, err := newSlackClient().PostMessage(msg.Channel, text, params)
+	return err
+}
+
+func (s *slackTransport) Post(channel, text string) (string, error) {
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	params.EscapeText = false
+
+	_, ts, err := newSlackClient().PostMessage(channel, text, params)
+	return ts, err
+}
+
+func (s *slackTransport) Update(channel, ts, text string) error {
+	_, _, _, err := newSlackClient().UpdateMessage(channel, ts, text)
+	return err
+}
+
+func (s *slackTransport) ChannelID(name string) (string, error) { return lookupChannel(name) }
+func (s *slackTransport) ChannelName(id string) string          { return slackIDToChannel(id) }
+func (s *slackTransport) Mention(id string) string              { return "<@" + id + ">" }
+
+func (s *slackTransport) UserName(uid string) string {
+	if lastConnectInfo != nil {
+		for _, user := range lastConnectInfo.Users {
+			if user.ID == uid {
+				return "@" + user.Name
+			}
+		}
+	}
+
+	return uid
+}
+
+func (s *slackTransport) Permalink(channel, ts string) string {
+	if lastConnectInfo == nil || channel == "" || ts == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"https://%s.slack.com/archives/%s/p%s",
+		lastConnectInfo.Team.Domain, channel, strings.Replace(ts, ".", "", 1),
+	)
+}
+
 var channelCache = struct {
 	sync.Mutex
 	cache map[string]string
@@ -204,42 +292,3 @@ func slackMsgFromReaction(channel string, ts string) (hist *slack.History) {
 
 	return hist
 }
-
-func slackReply(msg *slack.Message, thread bool, text string) {
-	api := newSlackClient()
-
-	params := slack.NewPostMessageParameters()
-	params.AsUser = true
-	params.EscapeText = false
-
-	if thread {
-		params.ThreadTimestamp = msg.ThreadTimestamp
-		if params.ThreadTimestamp == "" {
-			params.ThreadTimestamp = msg.Timestamp
-		}
-	}
-
-	_, _, err := api.PostMessage(msg.Channel, text, params)
-
-	if err != nil {
-		logger.Printf("error replying to %s:%s: %s", msg.Channel, msg.User, err)
-	}
-}
-
-func slackRefToMessage(channel, user, ts string) *slack.Message {
-	return &slack.Message{Msg: slack.Msg{Channel: channel, Timestamp: ts, User: user}}
-}
-
-func catchPanic(msg *slack.Message) {
-	if r := recover(); r != nil {
-
-		threaded := true
-		if ch, err := slackChannelID(conf.IncomingChannel); err == nil {
-			if ch != msg.Channel {
-				msg.Channel = ch
-				threaded = false
-			}
-		}
-		slackReply(msg, threaded, fmt.Sprintf("An exception occurred (`panic: %s`), poke lstanley. restarting bot.", r))
-	}
-}
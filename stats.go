@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// recentSamples is the amount of recent probe results used when checking if
+// a host has crossed the configured loss/latency thresholds.
+const recentSamples = 20
+
+// Sample is a single probe result, as tracked by LatencyStats.
+type Sample struct {
+	Latency time.Duration
+	Lost    bool
+}
+
+// LatencyStats tracks the latency (the duration of each probe, see
+// ProbeResult.Latency) and loss of a check over the lifetime of the watch.
+type LatencyStats struct {
+	Sent  int
+	Lost  int
+	Min   time.Duration
+	Max   time.Duration
+	Sum   time.Duration
+	SumSq float64
+
+	// Recent are the most recent samples, used to compare against the
+	// configured thresholds.
+	Recent []Sample
+}
+
+// Add adds a probe result to the stats.
+func (s *LatencyStats) Add(result *ProbeResult) {
+	s.Sent++
+
+	sample := Sample{Latency: result.Latency, Lost: !result.Online()}
+	s.Recent = append(s.Recent, sample)
+	if len(s.Recent) > recentSamples {
+		s.Recent = s.Recent[len(s.Recent)-recentSamples:]
+	}
+
+	if sample.Lost {
+		s.Lost++
+		return
+	}
+
+	if s.Min == 0 || sample.Latency < s.Min {
+		s.Min = sample.Latency
+	}
+
+	if sample.Latency > s.Max {
+		s.Max = sample.Latency
+	}
+
+	s.Sum += sample.Latency
+	s.SumSq += float64(sample.Latency) * float64(sample.Latency)
+}
+
+// Avg returns the average latency of all successful probes.
+func (s *LatencyStats) Avg() time.Duration {
+	if s.Sent == s.Lost {
+		return 0
+	}
+
+	return s.Sum / time.Duration(s.Sent-s.Lost)
+}
+
+// StdDev returns the standard deviation of the latency of all successful
+// probes.
+func (s *LatencyStats) StdDev() time.Duration {
+	n := float64(s.Sent - s.Lost)
+	if n == 0 {
+		return 0
+	}
+
+	mean := float64(s.Sum) / n
+	return time.Duration(math.Sqrt(math.Max(s.SumSq/n-mean*mean, 0)))
+}
+
+// Loss returns the percentage of probes which have failed.
+func (s *LatencyStats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+
+	return float64(s.Lost) / float64(s.Sent) * 100
+}
+
+// RecentLoss returns the percentage of recent probes which have failed.
+func (s *LatencyStats) RecentLoss() float64 {
+	if len(s.Recent) == 0 {
+		return 0
+	}
+
+	var lost int
+	for _, sample := range s.Recent {
+		if sample.Lost {
+			lost++
+		}
+	}
+
+	return float64(lost) / float64(len(s.Recent)) * 100
+}
+
+// RecentAvg returns the average latency of recent successful probes.
+func (s *LatencyStats) RecentAvg() time.Duration {
+	var sum time.Duration
+	var n int
+	for _, sample := range s.Recent {
+		if !sample.Lost {
+			sum += sample.Latency
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return sum / time.Duration(n)
+}
+
+// Degraded returns the reason the recent probes have crossed the configured
+// loss or latency thresholds, or an empty string if they haven't.
+func (s *LatencyStats) Degraded() string {
+	// Don't alert until there are enough samples to be meaningful.
+	if len(s.Recent) < recentSamples/2 {
+		return ""
+	}
+
+	if conf.Thresholds.LossPercent > 0 && s.RecentLoss() >= conf.Thresholds.LossPercent {
+		return fmt.Sprintf("%.0f%% loss over the last %d probes", s.RecentLoss(), len(s.Recent))
+	}
+
+	max := time.Duration(conf.Thresholds.LatencyMS) * time.Millisecond
+	if max > 0 && s.RecentAvg() >= max {
+		return fmt.Sprintf("average latency of %s over the last %d probes", s.RecentAvg().Truncate(time.Millisecond/10), len(s.Recent))
+	}
+
+	return ""
+}
+
+func (s *LatencyStats) String() string {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+	return fmt.Sprintf(
+		"latency min/avg/max/mdev: %.1f/%.1f/%.1f/%.1fms, loss: %.1f%%",
+		ms(s.Min), ms(s.Avg()), ms(s.Max), ms(s.StdDev()), s.Loss(),
+	)
+}
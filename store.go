@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/asdine/storm"
+)
+
+// checkBucket is the bucket that active checks are stored in, so they can be
+// resumed after a restart.
+const checkBucket = "checks"
+
+// resumedChecks is the number of checks which were resumed on startup.
+var resumedChecks int
+
+// storeMu is held while saving a check, and while stopping one, so a check
+// which was just removed is never saved again.
+var storeMu sync.Mutex
+
+// save stores the current state of the host, so it can be resumed after a
+// restart. It must only be called by the goroutine watching the host (or
+// before it has started), see Host.mu.
+func (h *Host) save() {
+	if h.Pinned {
+		// Pinned checks are started from the configuration instead.
+		return
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	select {
+	case <-h.closer:
+		// Host has already been removed.
+		return
+	default:
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := getDB().From(checkBucket).Save(h); err != nil {
+		logger.Printf("unable to save check %s: %s", h.ID, err)
+	}
+}
+
+// stop stops watching the host, and removes it from the store.
+func (h *Host) stop(id string) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	close(h.closer)
+	deleteHost(id)
+}
+
+// deleteHost removes a stored check.
+func deleteHost(id string) {
+	db := getDB()
+
+	err := db.From(checkBucket).DeleteStruct(&Host{ID: id})
+	if err != nil && err != storm.ErrNotFound {
+		logger.Printf("unable to delete check %s: %s", id, err)
+	}
+}
+
+// resumeChecks loads and starts watching all stored checks, returning the
+// amount of checks which were resumed.
+func resumeChecks() (resumed int) {
+	var hosts []*Host
+
+	err := getDB().From(checkBucket).All(&hosts)
+	if err != nil {
+		logger.Printf("unable to load stored checks: %s", err)
+		return 0
+	}
+
+	for _, host := range hosts {
+		probe, ip, err := newProbe(host.Query, host.Options)
+		if err != nil {
+			logger.Printf("unable to resume check %s: %s", host.ID, err)
+			deleteHost(host.ID)
+			continue
+		}
+
+		host.closer = make(chan struct{}, 1)
+		host.Probe = probe
+		if host.Cadence.Attempts == 0 {
+			host.Cadence = conf.Probe
+		}
+		if ip != nil {
+			host.IP = ip
+		}
+
+		if err = hostGroup.Add(host.ID, host); err != nil {
+			continue
+		}
+
+		go host.Watch()
+		resumed++
+	}
+
+	return resumed
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Message is a chat message, independent of the transport it was sent
+// through. The json tags match the fields of Slack messages, so checks
+// stored before transports were introduced can still be resumed.
+type Message struct {
+	// Transport is the name of the transport the message was sent through
+	// (see Transport.Name). If empty, the default transport is assumed.
+	Transport string `json:"transport,omitempty"`
+	Channel   string `json:"channel"`
+	User      string `json:"user"`
+	Text      string `json:"text"`
+	// Timestamp is the id of the message.
+	Timestamp string `json:"ts"`
+	// ThreadTimestamp is the id of the thread the message is in, if any.
+	ThreadTimestamp string `json:"thread_ts,omitempty"`
+}
+
+// Event is an incoming message, or a reaction added to (or removed from) a
+// message.
+type Event struct {
+	Message *Message
+
+	// Reaction is set if the event is a reaction to Message, by
+	// ReactionUser.
+	Reaction     string
+	ReactionUser string
+	// ReactionRemoved is true if the reaction was removed.
+	ReactionRemoved bool
+}
+
+// EventHandler handles events received by a transport.
+type EventHandler func(t Transport, ev *Event)
+
+// Transport is a chat service that ponger can receive commands from, and
+// send notifications to.
+type Transport interface {
+	// Name returns the name of the transport (e.g. "slack"), which is stored
+	// with messages received through it.
+	Name() string
+	// Run connects to the chat service, and passes incoming events to the
+	// handler until the connection fails.
+	Run(handler EventHandler) error
+	// IncomingChannel returns the name of the channel that checks are
+	// automatically started from, and notifications are posted to.
+	IncomingChannel() string
+
+	// Reply responds to a message, in its thread if thread is true. msg
+	// always has a Timestamp (or ThreadTimestamp) when thread is true, see
+	// replyTo.
+	Reply(msg *Message, thread bool, text string) error
+	// Post sends a message to a channel (not in a thread), returning the id
+	// of the message so it can be updated later.
+	Post(channel, text string) (string, error)
+	// Update replaces the text of a message previously sent with Post.
+	Update(channel, id, text string) error
+
+	// ChannelID returns the id of the provided channel name (e.g. "#ops").
+	ChannelID(name string) (string, error)
+	// ChannelName returns the name of the provided channel id, or an empty
+	// string if it's a private message.
+	ChannelName(id string) string
+	// UserName returns the name of the provided user id, without
+	// highlighting them.
+	UserName(id string) string
+	// Mention returns text which highlights the provided user id.
+	Mention(id string) string
+	// Permalink returns a link to the provided message, or an empty string
+	// if the transport doesn't support links.
+	Permalink(channel, id string) string
+}
+
+var transports = struct {
+	sync.Mutex
+	byName map[string]Transport
+	// def is the default transport, used for messages without a transport,
+	// and for notifications which aren't related to a specific message
+	// (e.g. incidents).
+	def Transport
+}{byName: make(map[string]Transport)}
+
+// registerTransport adds a transport. The first transport which is
+// registered is the default transport.
+func registerTransport(t Transport) {
+	transports.Lock()
+	defer transports.Unlock()
+
+	transports.byName[t.Name()] = t
+	if transports.def == nil {
+		transports.def = t
+	}
+}
+
+// getTransport returns the transport with the provided name, or the default
+// transport if the name is empty or unknown.
+func getTransport(name string) Transport {
+	transports.Lock()
+	defer transports.Unlock()
+
+	if t, ok := transports.byName[name]; ok {
+		return t
+	}
+
+	return transports.def
+}
+
+// runTransports runs all registered transports, returning once any of them
+// stops.
+func runTransports() error {
+	transports.Lock()
+	if len(transports.byName) == 0 {
+		transports.Unlock()
+		return errors.New("no transports configured")
+	}
+
+	errs := make(chan error, len(transports.byName))
+	for _, t := range transports.byName {
+		go func(t Transport) {
+			if err := t.Run(msgHandler); err != nil {
+				errs <- fmt.Errorf("%s: %s", t.Name(), err)
+				return
+			}
+
+			errs <- nil
+		}(t)
+	}
+	transports.Unlock()
+
+	return <-errs
+}
+
+// restartMessage is the message transports send to their incoming channel
+// once they first connect.
+func restartMessage() string {
+	if resumedChecks > 0 {
+		return fmt.Sprintf("_bot has been restarted (resumed %d checks)_", resumedChecks)
+	}
+
+	return "_bot has been restarted_"
+}
+
+// replyTo responds to a message through the transport it was received from.
+// Messages without an id (e.g. the origin of pinned checks, which is only a
+// channel) have no thread, so the text is posted to the channel instead.
+func replyTo(msg *Message, thread bool, text string) {
+	t := getTransport(msg.Transport)
+
+	var err error
+	if msg.Timestamp == "" && msg.ThreadTimestamp == "" {
+		_, err = t.Post(msg.Channel, text)
+	} else {
+		err = t.Reply(msg, thread, text)
+	}
+
+	if err != nil {
+		logger.Printf("error replying to %s:%s:%s: %s", t.Name(), msg.Channel, msg.User, err)
+	}
+}
+
+// userName returns the name of the provided user, without highlighting them.
+func userName(transport, uid string) string {
+	return getTransport(transport).UserName(uid)
+}
+
+// emojiShortcodes are the (Slack) emoji shortcodes used in notifications, for
+// transports which don't support them.
+var emojiShortcodes = strings.NewReplacer(
+	":white_check_mark:", "✅",
+	":warn1:", "⚠️",
+	":warning:", "⚠️",
+	":rotating_light:", "🚨",
+	":table_tennis_paddle_and_ball:", "🏓",
+	":ping_pong:", "🏓",
+)
+
+// replaceShortcodes replaces known emoji shortcodes with their unicode
+// equivalent.
+func replaceShortcodes(text string) string {
+	return emojiShortcodes.Replace(text)
+}
+
+// defaultReactionEmoji is used in place of the top-level reaction trigger by
+// transports without Slack's custom emoji.
+const defaultReactionEmoji = "🏓"
+
+// reactionEmoji returns the reaction trigger for transports other than
+// Slack, with known shortcodes replaced by their emoji. If trigger is empty,
+// the top-level reaction trigger is used, or defaultReactionEmoji if it's a
+// custom Slack emoji (which other transports don't have).
+func reactionEmoji(trigger string) string {
+	custom := trigger != ""
+	if !custom {
+		trigger = conf.ReactionTrigger
+	}
+
+	code := ":" + strings.Trim(trigger, ":") + ":"
+	if emoji := replaceShortcodes(code); emoji != code {
+		return emoji
+	}
+
+	if !custom {
+		return defaultReactionEmoji
+	}
+
+	return trigger
+}
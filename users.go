@@ -1,6 +1,10 @@
 package main
 
-import "github.com/asdine/storm"
+import (
+	"sync"
+
+	"github.com/asdine/storm"
+)
 
 type UserSettings struct {
 	ID             string `storm:"id"`
@@ -8,8 +12,7 @@ type UserSettings struct {
 }
 
 func GetAllUserSettings() (settings []*UserSettings) {
-	db := newUserDB()
-	defer db.Close()
+	db := getDB()
 
 	err := db.All(&settings)
 	if err != nil {
@@ -20,8 +23,7 @@ func GetAllUserSettings() (settings []*UserSettings) {
 }
 
 func GetUserSettings(user string) (settings *UserSettings) {
-	db := newUserDB()
-	defer db.Close()
+	db := getDB()
 
 	settings = &UserSettings{ID: user}
 
@@ -34,8 +36,7 @@ func GetUserSettings(user string) (settings *UserSettings) {
 }
 
 func SetUserSettings(settings *UserSettings) {
-	db := newUserDB()
-	defer db.Close()
+	db := getDB()
 
 	err := db.Save(settings)
 	if err == storm.ErrAlreadyExists {
@@ -46,11 +47,20 @@ func SetUserSettings(settings *UserSettings) {
 	}
 }
 
-func newUserDB() *storm.DB {
-	db, err := storm.Open(flags.UserDB)
-	if err != nil {
-		panic(err)
-	}
+var database struct {
+	once sync.Once
+	db   *storm.DB
+}
+
+// getDB returns the database, which is opened on first use and shared by
+// all goroutines (it's safe for concurrent use). It shouldn't be closed.
+func getDB() *storm.DB {
+	database.once.Do(func() {
+		var err error
+		if database.db, err = storm.Open(flags.UserDB); err != nil {
+			panic(err)
+		}
+	})
 
-	return db
+	return database.db
 }